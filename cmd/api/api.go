@@ -1,18 +1,43 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/RakibulBh/shaheed-backend/internal/keys"
+	"github.com/RakibulBh/shaheed-backend/internal/mail"
+	"github.com/RakibulBh/shaheed-backend/internal/moderation"
+	"github.com/RakibulBh/shaheed-backend/internal/oidc"
+	"github.com/RakibulBh/shaheed-backend/internal/ratelimit"
 	"github.com/RakibulBh/shaheed-backend/internal/store"
+	"github.com/RakibulBh/shaheed-backend/internal/textnorm"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/redis/go-redis/v9"
 )
 
 type application struct {
-	config config
-	store  store.Storage
+	config     config
+	lifecycle  *lifecycle
+	db         *sql.DB
+	redis      *redis.Client
+	store      store.Storage
+	keys       *keys.KeySet
+	moderator  moderation.Moderator
+	normalizer *textnorm.Normalizer
+	limiter    ratelimit.Limiter
+	mailer     mail.Mailer
+	templates  *mail.Templates
+	policies   *rolePolicies
+	oidc       map[string]*oidc.Provider
 }
 
 type dbConfig struct {
@@ -20,21 +45,59 @@ type dbConfig struct {
 	maxOpenConns int
 	maxIdleConns int
 	maxIdleTime  string
+	autoMigrate  bool
 }
 
-type llmConfig struct {
-	model  string
-	apiKey string
+type moderationConfig struct {
+	provider    string
+	model       string
+	apiKey      string
+	baseURL     string
+	bannedWords []string
+	timeout     time.Duration
 }
 
 type config struct {
-	addr   string
-	db     dbConfig
-	redis  redisConfig
-	llm    llmConfig
-	auth   auth
-	env    string
-	apiURL string
+	addr       string
+	db         dbConfig
+	redis      redisConfig
+	moderation moderationConfig
+	auth       auth
+	shutdown   shutdownConfig
+	search     searchConfig
+	health     healthConfig
+	rateLimit  rateLimitConfig
+	mail       mailConfig
+	oidc       oidcConfig
+	env        string
+	apiURL     string
+	appURL     string
+}
+
+type rateLimitConfig struct {
+	enabled        bool
+	login          rateLimitPolicy
+	register       rateLimitPolicy
+	verifyEmail    rateLimitPolicy
+	authTokens     rateLimitPolicy
+	authEmails     rateLimitPolicy
+	magicLinkEmail rateLimitPolicy // keyed by email address, not client
+	postQuestion   rateLimitPolicy
+	reads          rateLimitPolicy
+}
+
+type healthConfig struct {
+	timeout         time.Duration
+	checkModeration bool
+}
+
+type searchConfig struct {
+	synonymRefresh time.Duration
+}
+
+type shutdownConfig struct {
+	timeout    time.Duration
+	drainDelay time.Duration
 }
 
 type redisConfig struct {
@@ -46,8 +109,28 @@ type redisConfig struct {
 
 type auth struct {
 	jwtSecret  string
+	keysDir    string
+	signingKID string
+	legacyHMAC bool
+	issuer     string
+	audience   string
 	exp        time.Duration
 	refreshExp time.Duration
+	lockout    lockoutConfig
+
+	// Refresh tokens are stored as an HMAC keyed with the pepper, changing it
+	// signs everyone out. API keys don't use it.
+	tokenPepper        string
+	tokenRetention     time.Duration
+	tokenPurgeInterval time.Duration
+
+	challengeExp         time.Duration
+	totpIssuer           string
+	policyRefresh        time.Duration
+	magicLinkTTL         time.Duration
+	resetTTL             time.Duration
+	verificationTTL      time.Duration
+	requireVerifiedEmail bool
 }
 
 func (app *application) mount() http.Handler {
@@ -64,36 +147,128 @@ func (app *application) mount() http.Handler {
 		AllowedOrigins: []string{"https://*", "http://*"},
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Device-Label"},
+		ExposedHeaders:   []string{"Link", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"},
 		AllowCredentials: false,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
 
+	r.Get("/.well-known/jwks.json", app.JWKS)
+
 	// Healthcheck
 	r.Route("/health", func(r chi.Router) {
-		r.Get("/", app.Healthcheck)
+		r.Get("/", app.Readiness)
+		r.Get("/live", app.Liveness)
+		r.Get("/ready", app.Readiness)
 	})
 
 	r.Route("/v1", func(r chi.Router) {
 		r.Route("/questions", func(r chi.Router) {
-			r.Get("/", app.GetQuestions)
-			r.Get("/{id}", app.GetQuestion)
+			r.Group(func(r chi.Router) {
+				r.Use(app.RateLimit(app.config.rateLimit.reads))
+				r.Get("/", app.GetQuestions)
+				r.Get("/{id}", app.GetQuestion)
+				r.Get("/{id}/answers", app.GetAnswers)
+			})
 
 			// Require authentication
 			r.Group(func(r chi.Router) {
 				r.Use(app.Authenticate)
-				r.Post("/", app.PostQuestion)
+				r.Use(app.RequireScope(store.APIKeyScopePost))
+				// Every new question costs an LLM call
+				r.With(app.RequireVerifiedEmail, app.RateLimit(app.config.rateLimit.postQuestion)).Post("/", app.PostQuestion)
 				r.Put("/{id}", app.UpdateQuestion)
 				r.Delete("/{id}", app.DeleteQuestion)
+				r.Put("/{id}/vote", app.VoteQuestion)
 			})
 		})
 
+		r.With(app.RateLimit(app.config.rateLimit.reads)).Get("/search", app.Search)
+
 		r.Route("/auth", func(r chi.Router) {
-			r.Post("/register", app.Register)
-			r.Post("/login", app.Login)
-			// r.Post("/logout", app.Logout)
+			r.With(app.RateLimit(app.config.rateLimit.register)).Post("/register", app.Register)
+			r.With(app.RateLimit(app.config.rateLimit.login)).Post("/login", app.Login)
+			r.With(app.RateLimit(app.config.rateLimit.login)).Post("/login/2fa", app.LoginTwoFactor)
+
+			r.Route("/oidc/{provider}", func(r chi.Router) {
+				r.Use(app.RateLimit(app.config.rateLimit.login))
+				r.Get("/start", app.OIDCStart)
+				r.Get("/callback", app.OIDCCallback)
+				r.Post("/callback", app.OIDCCallback)
+			})
 			r.Get("/refresh", app.Refresh)
+			r.With(app.RateLimit(app.config.rateLimit.authTokens)).Post("/unlock", app.UnlockAccount)
+			r.With(app.RateLimit(app.config.rateLimit.authTokens)).Post("/verify-email", app.VerifyEmail)
+			r.With(app.RateLimit(app.config.rateLimit.authEmails)).Post("/forgot-password", app.ForgotPassword)
+			r.With(app.RateLimit(app.config.rateLimit.authTokens)).Post("/reset-password", app.ResetPassword)
+			r.With(app.RateLimit(app.config.rateLimit.authEmails)).Post("/magic-link", app.RequestMagicLink)
+			r.With(app.RateLimit(app.config.rateLimit.authTokens)).Post("/magic-link/verify", app.VerifyMagicLink)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.Authenticate)
+				r.Use(app.RequireSession)
+				r.Post("/logout", app.Logout)
+				r.Post("/logout-all", app.LogoutAll)
+				r.With(app.RateLimit(app.config.rateLimit.verifyEmail)).Post("/verify-email/resend", app.ResendVerificationEmail)
+				r.With(app.RateLimit(app.config.rateLimit.authTokens)).Post("/change-password", app.ChangePassword)
+
+				r.Route("/2fa", func(r chi.Router) {
+					r.Use(app.RateLimit(app.config.rateLimit.authTokens))
+					r.Post("/enroll", app.EnrollTwoFactor)
+					r.Post("/confirm", app.ConfirmTwoFactor)
+					r.Post("/disable", app.DisableTwoFactor)
+				})
+			})
+		})
+
+		r.Route("/me", func(r chi.Router) {
+			r.Use(app.Authenticate)
+			r.Use(app.RequireSession)
+			r.Get("/sessions", app.ListSessions)
+			r.Delete("/sessions/{id}", app.RevokeSession)
+
+			r.Get("/api-keys", app.ListAPIKeys)
+			r.Post("/api-keys", app.CreateAPIKey)
+			r.Put("/api-keys/{id}", app.RenameAPIKey)
+			r.Delete("/api-keys/{id}", app.RevokeAPIKey)
+		})
+
+		r.Route("/moderation", func(r chi.Router) {
+			r.Use(app.Authenticate)
+			r.Use(app.RequireRole(store.RoleModerator))
+			r.Get("/flagged", app.ListFlagged)
+			r.Get("/flagged/{id}", app.GetFlagged)
+			r.Post("/flagged/{id}/approve", app.ApproveFlagged)
+			r.Post("/flagged/{id}/reject", app.RejectFlagged)
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(app.Authenticate)
+			r.Use(app.RequireRole(store.RoleAdmin))
+
+			r.Route("/search", func(r chi.Router) {
+				r.Get("/synonyms", app.ListSynonyms)
+				r.Put("/synonyms", app.PutSynonym)
+				r.Delete("/synonyms/{term}", app.DeleteSynonym)
+				r.Post("/reindex", app.ReindexSearch)
+			})
+
+			r.Get("/role-policies", app.ListRolePolicies)
+			r.Put("/role-policies", app.PutRolePolicy)
+
+			r.Get("/locked-users", app.ListLockedUsers)
+			r.Post("/users/{id}/unlock", app.UnlockUser)
+
+			// Admin keys may look up and revoke keys but not issue them
+			r.Get("/api-keys", app.AdminListAPIKeys)
+			r.Delete("/api-keys/{id}", app.AdminRevokeAPIKey)
+			r.With(app.RequireSession).Post("/users/{id}/api-keys", app.AdminCreateAPIKey)
+		})
+
+		r.Route("/users", func(r chi.Router) {
+			r.Use(app.Authenticate)
+			r.Use(app.RequireRole(store.RoleAdmin))
+			r.Put("/{id}/role", app.UpdateUserRole)
 		})
 	})
 
@@ -110,5 +285,41 @@ func (app *application) run(mux http.Handler) error {
 		IdleTimeout:  time.Second * 60,
 	}
 
-	return srv.ListenAndServe()
+	shutdownErr := make(chan error)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-quit
+
+		log.Printf("caught %s, shutting down", sig)
+
+		// Fail readiness first and give the load balancer time to notice
+		app.lifecycle.draining.Store(true)
+		time.Sleep(app.config.shutdown.drainDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdown.timeout)
+		defer cancel()
+
+		// Wait for in-flight requests, then stop the background workers
+		err := srv.Shutdown(ctx)
+
+		shutdownErr <- errors.Join(err, app.lifecycle.stop(ctx))
+	}()
+
+	log.Printf("starting server on %s", app.config.addr)
+
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownErr
+	if err != nil {
+		return err
+	}
+
+	log.Print("server stopped")
+
+	return nil
 }
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RakibulBh/shaheed-backend/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type CreateAPIKeyRequest struct {
+	Name      string            `json:"name"`
+	Scope     store.APIKeyScope `json:"scope"`
+	ExpiresAt *time.Time        `json:"expires_at"`
+}
+
+type RenameAPIKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// validAPIKeyName trims the name and reports whether it is usable
+func validAPIKeyName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	return name, name != "" && len(name) <= 100
+}
+
+// apiKeyID reads the key id from the URL
+func apiKeyID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+}
+
+// createAPIKey validates the request and issues a key to owner
+func (app *application) createAPIKey(w http.ResponseWriter, r *http.Request, owner store.User, createdBy int) {
+
+	var payload CreateAPIKeyRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	name, ok := validAPIKeyName(payload.Name)
+	if !ok {
+		app.badRequestResponse(w, r, errors.New("name must be between 1 and 100 characters"))
+		return
+	}
+
+	if !payload.Scope.Valid() {
+		app.badRequestResponse(w, r, errors.New("invalid scope"))
+		return
+	}
+
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		app.badRequestResponse(w, r, errors.New("expires_at must be in the future"))
+		return
+	}
+
+	// A key can't do more than its owner. Users issuing themselves an elevated
+	// key must meet their role's two-factor policy, admins already have.
+	if !owner.Role.AtLeast(payload.Scope.Role()) {
+		app.forbiddenResponse(w, r, errors.New("the key's owner doesn't have the role this scope needs"))
+		return
+	}
+	if createdBy == owner.ID && payload.Scope.Role() != store.RoleMember && !app.hasRequiredTwoFactor(owner) {
+		app.forbiddenResponse(w, r, ErrTwoFactorRequired)
+		return
+	}
+
+	key, plain, err := app.store.APIKeys.Create(r.Context(), store.NewAPIKey{
+		UserID:    owner.ID,
+		Name:      name,
+		Scope:     payload.Scope,
+		CreatedBy: createdBy,
+		ExpiresAt: payload.ExpiresAt,
+	})
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, "api key created, store it now as it won't be shown again", map[string]any{
+		"api_key": key,
+		"key":     plain,
+	})
+}
+
+func (app *application) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+
+	user := getUserFromContext(r)
+
+	keys, err := app.store.APIKeys.List(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "api keys fetched", keys)
+}
+
+func (app *application) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	user := getUserFromContext(r)
+
+	app.createAPIKey(w, r, user, user.ID)
+}
+
+func (app *application) RenameAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	id, err := apiKeyID(r)
+	if err != nil {
+		app.notFoundResponse(w, r, errors.New("api key not found"))
+		return
+	}
+
+	var payload RenameAPIKeyRequest
+	err = app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	name, ok := validAPIKeyName(payload.Name)
+	if !ok {
+		app.badRequestResponse(w, r, errors.New("name must be between 1 and 100 characters"))
+		return
+	}
+
+	err = app.store.APIKeys.Rename(r.Context(), getUserFromContext(r).ID, id, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("api key not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "api key renamed", nil)
+}
+
+func (app *application) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	id, err := apiKeyID(r)
+	if err != nil {
+		app.notFoundResponse(w, r, errors.New("api key not found"))
+		return
+	}
+
+	err = app.store.APIKeys.Revoke(r.Context(), getUserFromContext(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("api key not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "api key revoked", nil)
+}
+
+// AdminListAPIKeys lists every active key. With ?prefix= it finds the key a
+// prefix, or a whole key found in a log, belongs to.
+func (app *application) AdminListAPIKeys(w http.ResponseWriter, r *http.Request) {
+
+	prefix := ""
+	if query := strings.TrimSpace(r.URL.Query().Get("prefix")); query != "" {
+		prefix = store.APIKeyPrefix(query)
+		if prefix == "" {
+			app.badRequestResponse(w, r, errors.New("invalid api key prefix"))
+			return
+		}
+	}
+
+	keys, err := app.store.APIKeys.ListAll(r.Context(), prefix)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "api keys fetched", keys)
+}
+
+// AdminCreateAPIKey issues a key to another account, usually a service account
+func (app *application) AdminCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	owner, err := app.store.User.GetUserByID(r.Context(), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRows):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.createAPIKey(w, r, owner, getUserFromContext(r).ID)
+}
+
+func (app *application) AdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	id, err := apiKeyID(r)
+	if err != nil {
+		app.notFoundResponse(w, r, errors.New("api key not found"))
+		return
+	}
+
+	err = app.store.APIKeys.RevokeAny(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("api key not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "api key revoked", nil)
+}
@@ -1,15 +1,16 @@
 package main
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"log"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/RakibulBh/shaheed-backend/internal/store"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type RegisterRequest struct {
@@ -18,6 +19,7 @@ type RegisterRequest struct {
 	Email           string `json:"email"`
 	Password        string `json:"password"`
 	PasswordConfirm string `json:"password_confirm"`
+	Locale          string `json:"locale"`
 }
 
 func (app *application) Register(w http.ResponseWriter, r *http.Request) {
@@ -56,6 +58,15 @@ func (app *application) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Emails are sent in the user's language
+	if payload.Locale == "" {
+		payload.Locale = app.config.mail.defaultLocale
+	}
+	if !app.templates.Supports(payload.Locale) {
+		app.badRequestResponse(w, r, errors.New("unsupported locale"))
+		return
+	}
+
 	// Hash the passowrd
 	hash, err := app.store.Auth.HashPassword(payload.Password)
 	if err != nil {
@@ -64,12 +75,18 @@ func (app *application) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// store the user in the database
-	err = app.store.Auth.Register(ctx, store.RegisterRequest{FirstName: payload.FirstName, LastName: payload.LastName, Email: payload.Email, PasswordHash: hash})
+	userID, err := app.store.Auth.Register(ctx, store.RegisterRequest{FirstName: payload.FirstName, LastName: payload.LastName, Email: payload.Email, Locale: payload.Locale, PasswordHash: hash})
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
 	}
 
+	// The account exists either way, the user can ask for another email
+	err = app.sendVerificationEmail(ctx, store.User{ID: userID, FirstName: payload.FirstName, Email: payload.Email, Locale: payload.Locale})
+	if err != nil {
+		log.Printf("sending verification email to user %d: %v", userID, err)
+	}
+
 	app.writeJSON(w, http.StatusCreated, "registered successfully", nil)
 }
 
@@ -89,13 +106,28 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := r.Context()
+	ip := clientIP(r)
+
+	// Too many failures from this address, don't even look the user up
+	if app.loginBlocked(ctx, ip) {
+		app.rateLimitExceededResponse(w, r, errors.New("too many failed login attempts, try again later"))
+		return
+	}
 
 	// Fetch user from the database
 	user, err := app.store.User.GetUserByEmail(ctx, payload.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, store.ErrNoRows):
-			app.badRequestResponse(w, r, errors.New("user does not exist"))
+			// Spend the same time hashing as for a real account
+			bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(payload.Password))
+
+			err = app.loginFailed(ctx, ip, nil)
+			if err != nil {
+				app.internalServerErrorResponse(w, r, err)
+				return
+			}
+			app.badRequestResponse(w, r, ErrInvalidCredentials)
 			return
 		default:
 			app.internalServerErrorResponse(w, r, err)
@@ -103,6 +135,12 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Accounts created through an identity provider have no password, spend
+	// the time hashing anyway
+	if user.PasswordHash == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(payload.Password))
+	}
+
 	// Verify password matches with the database hash
 	passwordMatches, err := app.store.Auth.VerifyPassword(payload.Password, user.PasswordHash)
 	if err != nil {
@@ -110,27 +148,68 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !passwordMatches {
-		app.badRequestResponse(w, r, errors.New("invalid credentials"))
+	// A locked account fails the same way as a wrong password, the owner
+	// has been emailed an unlock link
+	if !passwordMatches || user.IsLocked() {
+		// Failures while locked only count against the IP, they don't extend the lock
+		account := &user
+		if user.IsLocked() {
+			account = nil
+		}
+
+		err = app.loginFailed(ctx, ip, account)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+		app.badRequestResponse(w, r, ErrInvalidCredentials)
 		return
 	}
 
-	// Generate a JWT token
-	accessToken, err := app.store.Auth.GenerateJWT(user.ID, time.Now().Add(app.config.auth.exp), app.config.auth.jwtSecret)
-	if err != nil {
-		app.internalServerErrorResponse(w, r, err)
+	// The password alone isn't enough, ask for the second factor. Earlier
+	// failures are only cleared once it is given.
+	if user.TwoFactorEnabled {
+		app.requestSecondFactor(w, r, user.ID)
 		return
 	}
 
-	// Generate a Refesh JWT token
-	refreshToken, err := app.store.Auth.GenerateJWT(user.ID, time.Now().Add(app.config.auth.refreshExp), app.config.auth.jwtSecret)
+	app.completeLogin(w, r, user)
+}
+
+// issueChallenge returns a short lived token to exchange, along with a second
+// factor, at /v1/auth/login/2fa
+func (app *application) issueChallenge(userID int) (string, error) {
+	return app.store.Auth.GenerateJWT(userID, "", store.TokenTypeChallenge, time.Now().Add(app.config.auth.challengeExp), app.tokenConfig())
+}
+
+// requestSecondFactor responds with a challenge instead of a token pair
+func (app *application) requestSecondFactor(w http.ResponseWriter, r *http.Request, userID int) {
+	challenge, err := app.issueChallenge(userID)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
 	}
 
-	// Store the refresh token in the database
-	err = app.store.Auth.StoreRefreshToken(ctx, user.ID, refreshToken, time.Now().Add(app.config.auth.refreshExp))
+	app.writeJSON(w, http.StatusAccepted, "two-factor authentication required", map[string]any{
+		"two_factor_required": true,
+		"challenge_token":     challenge,
+	})
+}
+
+// completeLogin clears failed attempts and responds with a new token pair
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user store.UserData) {
+	ctx := r.Context()
+
+	// Clear any earlier failures
+	if user.FailedLoginAttempts > 0 {
+		err := app.store.Lockout.Reset(ctx, user.ID)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	accessToken, refreshToken, err := app.issueTokenPair(r, user.ID)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
@@ -142,6 +221,32 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// issueTokenPair starts a new session with an access token and the first
+// refresh token of a new family
+func (app *application) issueTokenPair(r *http.Request, userID int) (string, string, error) {
+	sessionID := uuid.NewString()
+
+	// Generate a JWT token
+	accessToken, err := app.store.Auth.GenerateJWT(userID, sessionID, store.TokenTypeAccess, time.Now().Add(app.config.auth.exp), app.tokenConfig())
+	if err != nil {
+		return "", "", err
+	}
+
+	// Generate a Refesh JWT token
+	refreshToken, err := app.store.Auth.GenerateJWT(userID, sessionID, store.TokenTypeRefresh, time.Now().Add(app.config.auth.refreshExp), app.tokenConfig())
+	if err != nil {
+		return "", "", err
+	}
+
+	// Store the refresh token in the database
+	err = app.store.Auth.StoreRefreshToken(r.Context(), userID, sessionID, refreshToken, time.Now().Add(app.config.auth.refreshExp), requestDevice(r))
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
 func (app *application) Refresh(w http.ResponseWriter, r *http.Request) {
 
 	refreshToken := r.Header.Get("Authorization")
@@ -158,20 +263,14 @@ func (app *application) Refresh(w http.ResponseWriter, r *http.Request) {
 
 	refreshToken = parts[1]
 
-	// Validate if the token is valid
-	jwtToken, err := app.store.Auth.VerifyToken(refreshToken, app.config.auth.jwtSecret)
+	// Validate if the token is a valid refresh token
+	claims, err := app.store.Auth.VerifyToken(refreshToken, store.TokenTypeRefresh, app.tokenConfig())
 	if err != nil {
 		app.unauthorizedResponse(w, r, errors.New("invalid token"))
 		return
 	}
 
-	claims, ok := jwtToken.Claims.(jwt.MapClaims)
-	if !ok {
-		app.unauthorizedResponse(w, r, errors.New("invalid token"))
-		return
-	}
-
-	userID, err := strconv.ParseInt(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
+	userID, err := claims.UserID()
 	if err != nil {
 		app.unauthorizedResponse(w, r, errors.New("invalid token"))
 		return
@@ -180,9 +279,24 @@ func (app *application) Refresh(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	// Verify the refresh token and regenerate
-	accessToken, refreshToken, err := app.store.Auth.RefreshToken(ctx, int(userID), refreshToken, app.config.auth.jwtSecret, app.config.auth.refreshExp, app.config.auth.exp)
+	accessToken, refreshToken, err := app.store.Auth.RefreshToken(ctx, userID, refreshToken, clientIP(r), app.tokenConfig(), app.config.auth.refreshExp, app.config.auth.exp)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		switch {
+		case errors.Is(err, store.ErrRefreshTokenReused):
+			// The session may be in someone else's hands, cut off its access tokens too
+			if claims.SessionID != "" {
+				revokeErr := app.store.Revocations.RevokeSession(ctx, claims.SessionID, app.config.auth.exp)
+				if revokeErr != nil {
+					app.internalServerErrorResponse(w, r, revokeErr)
+					return
+				}
+			}
+			app.unauthorizedResponse(w, r, err)
+		case errors.Is(err, store.ErrInvalidRefreshToken):
+			app.unauthorizedResponse(w, r, err)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -194,3 +308,92 @@ func (app *application) Refresh(w http.ResponseWriter, r *http.Request) {
 		app.internalServerErrorResponse(w, r, err)
 	}
 }
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
+
+	// Parse the request
+	var payload LogoutRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	claims := r.Context().Value(tokenCtx).(*store.Claims)
+
+	ctx := r.Context()
+
+	// End the session the access token belongs to
+	if claims.SessionID != "" {
+		err = app.revokeSession(ctx, user.ID, claims.SessionID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Revoke the refresh token so this session can no longer be refreshed
+	if payload.RefreshToken != "" {
+		err = app.store.Auth.RevokeRefreshToken(ctx, user.ID, payload.RefreshToken)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Revoke the access token for the rest of its lifetime
+	err = app.store.Revocations.RevokeToken(ctx, claims.ID, time.Until(claims.ExpiresAt.Time))
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "logged out successfully", nil)
+}
+
+func (app *application) LogoutAll(w http.ResponseWriter, r *http.Request) {
+
+	user := getUserFromContext(r)
+
+	ctx := r.Context()
+
+	err := app.revokeAllSessions(ctx, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "logged out of all sessions", nil)
+}
+
+// revokeAllSessions revokes every refresh token for the user, all of the access
+// tokens issued to them so far and the API keys they issued to themselves.
+// Whoever held a session could have minted those keys, so they go too. Keys
+// issued to service accounts stay, admins revoke those explicitly.
+func (app *application) revokeAllSessions(ctx context.Context, userID int) error {
+	sessionIDs, err := app.store.Auth.RevokeUserRefreshTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// Access tokens from the ended sessions, including those from the current
+	// second which the per user revocation below leaves alone
+	for _, sessionID := range sessionIDs {
+		err = app.store.Revocations.RevokeSession(ctx, sessionID, app.config.auth.exp)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = app.store.APIKeys.RevokeSelfIssued(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return app.store.Revocations.RevokeUserTokens(ctx, userID, time.Now(), app.config.auth.exp)
+}
@@ -26,3 +26,15 @@ func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request,
 func (app *application) unauthorizedResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.errorJSON(w, err, http.StatusUnauthorized)
 }
+
+func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorJSON(w, err, http.StatusForbidden)
+}
+
+func (app *application) conflictResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorJSON(w, err, http.StatusConflict)
+}
+
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorJSON(w, err, http.StatusTooManyRequests)
+}
@@ -1,11 +1,126 @@
 package main
 
 import (
+	"context"
+	"log"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/RakibulBh/shaheed-backend/internal/moderation"
 )
 
 type envelope map[string]any
 
-func (app *application) Healthcheck(w http.ResponseWriter, r *http.Request) {
+// healthCheck is a single dependency checked by the readiness probe. When a
+// critical check fails the instance is reported as not ready.
+type healthCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+// checkResult is what the readiness probe reports for one dependency. Errors
+// are logged rather than returned as the endpoint is public.
+type checkResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	critical  bool
+}
+
+// Liveness only reports that the process is up and serving requests
+func (app *application) Liveness(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusOK, "success", envelope{"status": "ok"})
 }
+
+// Readiness checks every dependency and fails when a critical one is down or
+// the server is draining
+func (app *application) Readiness(w http.ResponseWriter, r *http.Request) {
+
+	// Tell the load balancer to stop routing to us while draining
+	if app.isDraining() {
+		app.writeJSON(w, http.StatusServiceUnavailable, "shutting down", envelope{"status": "draining"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.health.timeout)
+	defer cancel()
+
+	checks := app.healthChecks()
+	results := make(map[string]checkResult, len(checks))
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	for _, hc := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			err := hc.check(ctx)
+
+			latency := time.Since(start)
+
+			result := checkResult{
+				Status:    "ok",
+				LatencyMS: float64(latency.Microseconds()) / 1000,
+				critical:  hc.critical,
+			}
+			if err != nil {
+				result.Status = "fail"
+				log.Printf("readiness check %s failed after %s: %v", hc.name, latency.Round(time.Millisecond), err)
+			}
+
+			mu.Lock()
+			results[hc.name] = result
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	for _, result := range results {
+		if result.Status == "ok" {
+			continue
+		}
+		if result.critical {
+			status, code = "unavailable", http.StatusServiceUnavailable
+			break
+		}
+		status = "degraded"
+	}
+
+	app.writeJSON(w, code, status, envelope{"status": status, "checks": results})
+}
+
+// healthChecks lists the dependencies checked by the readiness probe
+func (app *application) healthChecks() []healthCheck {
+	checks := []healthCheck{
+		{
+			name:     "postgres",
+			critical: true,
+			check:    app.db.PingContext,
+		},
+		{
+			name:     "redis",
+			critical: true,
+			check: func(ctx context.Context) error {
+				return app.redis.Ping(ctx).Err()
+			},
+		},
+	}
+
+	if pinger, ok := app.moderator.(moderation.Pinger); ok && app.config.health.checkModeration {
+		checks = append(checks, healthCheck{
+			name:     "moderation",
+			critical: false,
+			check:    pinger.Ping,
+		})
+	}
+
+	return checks
+}
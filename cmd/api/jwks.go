@@ -0,0 +1,22 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// JWKS serves our public signing keys so other services can verify our tokens.
+// It is written in the standard JWKS format rather than our usual envelope.
+func (app *application) JWKS(w http.ResponseWriter, r *http.Request) {
+
+	js, err := json.Marshal(app.keys.JWKS())
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write(js)
+}
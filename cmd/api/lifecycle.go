@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// lifecycle tracks the background workers and shutdown hooks that have to be
+// stopped cleanly when the server shuts down
+type lifecycle struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	draining atomic.Bool
+
+	mu    sync.Mutex
+	hooks []func(ctx context.Context) error
+}
+
+func newLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{ctx: ctx, cancel: cancel}
+}
+
+// background runs fn in its own goroutine. The context it is given is cancelled
+// on shutdown and fn is expected to return promptly once it is.
+func (app *application) background(name string, fn func(ctx context.Context)) {
+	app.lifecycle.wg.Add(1)
+
+	go func() {
+		defer app.lifecycle.wg.Done()
+
+		// A panicking worker should not take the whole server down
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("background worker %s panicked: %v", name, err)
+			}
+		}()
+
+		fn(app.lifecycle.ctx)
+	}()
+}
+
+// onShutdown registers a hook run after the server has stopped accepting requests
+func (app *application) onShutdown(fn func(ctx context.Context) error) {
+	app.lifecycle.mu.Lock()
+	defer app.lifecycle.mu.Unlock()
+
+	app.lifecycle.hooks = append(app.lifecycle.hooks, fn)
+}
+
+// isDraining reports whether the server is shutting down
+func (app *application) isDraining() bool {
+	return app.lifecycle.draining.Load()
+}
+
+// stop cancels the background workers, waits for them to return and then runs
+// the shutdown hooks, giving up once ctx is done
+func (l *lifecycle) stop(ctx context.Context) error {
+	l.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+
+	var errs []error
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, errors.New("timed out waiting for background workers"))
+	}
+
+	l.mu.Lock()
+	hooks := l.hooks
+	l.mu.Unlock()
+
+	for _, hook := range hooks {
+		if err := hook(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RakibulBh/shaheed-backend/internal/store"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type lockoutConfig struct {
+	maxAttempts  int
+	duration     time.Duration
+	ipMaxFailure int
+	ipWindow     time.Duration
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+	unlockTTL    time.Duration
+}
+
+// dummyPasswordHash is compared against when the email is unknown so that
+// login takes as long whether or not the account exists
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// failedLoginDelay doubles with every failure, up to maxDelay
+func (app *application) failedLoginDelay(failures int) time.Duration {
+	cfg := app.config.auth.lockout
+	if failures < 1 || cfg.baseDelay <= 0 {
+		return 0
+	}
+
+	delay := cfg.baseDelay
+	for i := 1; i < failures && delay < cfg.maxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, cfg.maxDelay)
+}
+
+// loginBlocked reports whether the IP has failed too many logins recently.
+// Redis errors are logged and let the request through, the account lockout
+// still applies.
+func (app *application) loginBlocked(ctx context.Context, ip string) bool {
+	failures, err := app.store.Lockout.IPFailures(ctx, ip)
+	if err != nil {
+		log.Printf("lockout: checking failures for %s: %v", ip, err)
+		return false
+	}
+
+	return failures >= app.config.auth.lockout.ipMaxFailure
+}
+
+// loginFailed records a failed login against the IP and, when known, the
+// account, then slows the response down before the caller replies. The delay
+// only grows with the IP's failures so it can't tell known and unknown emails
+// apart.
+func (app *application) loginFailed(ctx context.Context, ip string, user *store.UserData) error {
+	cfg := app.config.auth.lockout
+
+	failures, err := app.store.Lockout.RecordIPFailure(ctx, ip, cfg.ipWindow)
+	if err != nil {
+		log.Printf("lockout: recording failure for %s: %v", ip, err)
+	}
+
+	if user != nil {
+		_, locked, err := app.store.Lockout.RecordFailure(ctx, user.ID, cfg.maxAttempts, cfg.duration)
+		if err != nil {
+			return err
+		}
+
+		if locked {
+			err = app.sendUnlockEmail(ctx, user)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	select {
+	case <-time.After(app.failedLoginDelay(failures)):
+	case <-ctx.Done():
+	}
+
+	return nil
+}
+
+func (app *application) sendUnlockEmail(ctx context.Context, user *store.UserData) error {
+	token, err := app.store.Tokens.Create(ctx, user.ID, store.ScopeUnlock, app.config.auth.lockout.unlockTTL)
+	if err != nil {
+		return err
+	}
+
+	return app.sendEmail(ctx, user.Email, user.Locale, "account_locked", map[string]any{
+		"Name":      user.FirstName,
+		"URL":       fmt.Sprintf("%s/unlock?token=%s", app.config.appURL, token),
+		"Attempts":  app.config.auth.lockout.maxAttempts,
+		"LockedFor": app.config.auth.lockout.duration,
+	})
+}
+
+type UnlockRequest struct {
+	Token string `json:"token"`
+}
+
+// UnlockAccount redeems the token from the lockout email
+func (app *application) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+
+	var payload UnlockRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	userID, err := app.store.Tokens.Consume(ctx, store.ScopeUnlock, payload.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidUserToken):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.store.Lockout.Reset(ctx, userID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "account unlocked", nil)
+}
+
+func (app *application) ListLockedUsers(w http.ResponseWriter, r *http.Request) {
+
+	users, err := app.store.Lockout.ListLocked(r.Context())
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "locked users fetched", users)
+}
+
+func (app *application) UnlockUser(w http.ResponseWriter, r *http.Request) {
+
+	id := chi.URLParam(r, "id")
+
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.store.Lockout.Reset(r.Context(), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRows):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "user unlocked", nil)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/RakibulBh/shaheed-backend/internal/store"
+)
+
+// The device secret is also set as a cookie for browsers on the API's own origin
+const magicLinkCookie = "magic_link_device"
+
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+	// Defaults to true. Clients that can't keep the device token, or users
+	// opening the link somewhere else, can ask for an unbound link.
+	BindDevice *bool `json:"bind_device"`
+}
+
+// RequestMagicLink emails a single use sign in link. It answers the same way
+// whether or not the account exists.
+func (app *application) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+
+	var payload MagicLinkRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	bind := payload.BindDevice == nil || *payload.BindDevice
+
+	var binding string
+	if bind {
+		binding, err = store.NewDeviceBinding()
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Limit links per address as well as per IP, so one inbox can't be flooded
+	policy := app.config.rateLimit.magicLinkEmail
+	result, err := app.limiter.Allow(ctx, fmt.Sprintf("ratelimit:%s:email:%s", policy.name, strings.ToLower(payload.Email)), policy.limit, policy.window)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	user, err := app.store.User.GetUserByEmail(ctx, payload.Email)
+	if err != nil && !errors.Is(err, store.ErrNoRows) {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err == nil && result.Allowed {
+		token, err := app.store.Tokens.CreateBound(ctx, user.ID, store.ScopeMagicLink, app.config.auth.magicLinkTTL, binding)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.sendEmail(ctx, user.Email, user.Locale, "magic_link", map[string]any{
+			"Name":      user.FirstName,
+			"URL":       fmt.Sprintf("%s/magic-link?token=%s", app.config.appURL, token),
+			"ExpiresIn": app.config.auth.magicLinkTTL,
+		})
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+	} else if err == nil {
+		log.Printf("magic link: throttled for user %d", user.ID)
+	}
+
+	var data map[string]string
+	if bind {
+		http.SetCookie(w, &http.Cookie{
+			Name:     magicLinkCookie,
+			Value:    binding,
+			Path:     "/v1/auth/magic-link",
+			MaxAge:   int(app.config.auth.magicLinkTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   app.config.env == "production",
+			SameSite: http.SameSiteLaxMode,
+		})
+		data = map[string]string{"device_token": binding}
+	}
+
+	app.writeJSON(w, http.StatusAccepted, "if the account exists, a sign in link has been sent", data)
+}
+
+type VerifyMagicLinkRequest struct {
+	Token       string `json:"token"`
+	DeviceToken string `json:"device_token"`
+}
+
+// VerifyMagicLink exchanges a magic link for a token pair
+func (app *application) VerifyMagicLink(w http.ResponseWriter, r *http.Request) {
+
+	var payload VerifyMagicLinkRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Fall back to the cookie set when the link was requested
+	if payload.DeviceToken == "" {
+		if cookie, err := r.Cookie(magicLinkCookie); err == nil {
+			payload.DeviceToken = cookie.Value
+		}
+	}
+
+	ctx := r.Context()
+
+	userID, err := app.store.Tokens.ConsumeBound(ctx, store.ScopeMagicLink, payload.Token, payload.DeviceToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidUserToken):
+			app.badRequestResponse(w, r, errors.New("invalid or expired link, or it was requested from another device"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: magicLinkCookie, Path: "/v1/auth/magic-link", MaxAge: -1})
+
+	// Following the link proves the user owns the address
+	err = app.store.User.MarkEmailVerified(ctx, userID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	user, err := app.store.User.GetUserDataByID(ctx, userID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		app.requestSecondFactor(w, r, user.ID)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/RakibulBh/shaheed-backend/internal/mail"
+	"github.com/RakibulBh/shaheed-backend/internal/store"
+)
+
+type mailConfig struct {
+	transport     string
+	from          string
+	smtpHost      string
+	smtpPort      int
+	smtpUsername  string
+	smtpPassword  string
+	dir           string
+	defaultLocale string
+	pollInterval  time.Duration
+	batchSize     int
+	maxAttempts   int
+	retryBase     time.Duration
+	retention     time.Duration
+}
+
+// How often sent and abandoned mail older than the retention is deleted
+const mailPurgeInterval = time.Hour
+
+// sendEmail renders the template in the recipient's language and queues it.
+// Delivery happens in the background so a slow or unavailable SMTP server
+// never fails the request.
+func (app *application) sendEmail(ctx context.Context, to string, locale string, template string, data map[string]any) error {
+	msg, err := app.templates.Render(template, locale, data)
+	if err != nil {
+		return err
+	}
+
+	return app.store.Outbox.Enqueue(ctx, store.OutboxMessage{
+		Recipient: to,
+		Subject:   msg.Subject,
+		TextBody:  msg.Text,
+		HTMLBody:  msg.HTML,
+	})
+}
+
+// deliverMail polls the outbox and hands due messages to the mailer. Now and
+// then it also clears out old messages.
+func (app *application) deliverMail(ctx context.Context) {
+	ticker := time.NewTicker(app.config.mail.pollInterval)
+	defer ticker.Stop()
+
+	purge := time.NewTicker(mailPurgeInterval)
+	defer purge.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := app.flushOutbox(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("delivering mail: %v", err)
+			}
+		case <-purge.C:
+			cutoff := time.Now().Add(-app.config.mail.retention)
+			if _, err := app.store.Outbox.Purge(ctx, app.config.mail.maxAttempts, cutoff); err != nil && ctx.Err() == nil {
+				log.Printf("purging mail: %v", err)
+			}
+		}
+	}
+}
+
+func (app *application) flushOutbox(ctx context.Context) error {
+	cfg := app.config.mail
+
+	// Hold each claim for longer than a send can take
+	messages, err := app.store.Outbox.Claim(ctx, cfg.batchSize, cfg.maxAttempts, time.Minute*5)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range messages {
+		sendCtx, cancel := context.WithTimeout(ctx, time.Second*30)
+		err := app.mailer.Send(sendCtx, mail.Message{
+			To:      msg.Recipient,
+			Subject: msg.Subject,
+			Text:    msg.TextBody,
+			HTML:    msg.HTMLBody,
+		})
+		cancel()
+
+		if err != nil {
+			if msg.Attempts >= cfg.maxAttempts {
+				log.Printf("giving up on mail %d to %s after %d attempts: %v", msg.ID, msg.Recipient, msg.Attempts, err)
+
+				err = app.store.Outbox.GiveUp(ctx, msg.ID, err.Error())
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			err = app.store.Outbox.MarkFailed(ctx, msg.ID, err.Error(), time.Now().Add(app.mailRetryDelay(msg.Attempts)))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = app.store.Outbox.MarkSent(ctx, msg.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mailRetryDelay doubles after every failed attempt, up to an hour
+func (app *application) mailRetryDelay(attempts int) time.Duration {
+	delay := app.config.mail.retryBase
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+
+	return min(delay, time.Hour)
+}
@@ -3,19 +3,20 @@ package main
 import (
 	"context"
 	"errors"
-	"fmt"
+	"log"
 	"net/http"
-	"strconv"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/RakibulBh/shaheed-backend/internal/store"
 )
 
 // UserIDKey is the key for the user ID in the request context
 type contextKey string
 
 const (
-	userCtx contextKey = "user"
+	userCtx   contextKey = "user"
+	tokenCtx  contextKey = "token"
+	apiKeyCtx contextKey = "apiKey"
 )
 
 // Authentication middleware
@@ -30,44 +31,140 @@ func (app *application) Authenticate(next http.Handler) http.Handler {
 
 		// Split the header and see if it is a bearer token
 		parts := strings.Split(authHeader, " ")
-		if parts[0] != "Bearer" || len(parts[1]) == 0 {
+		if len(parts) != 2 || parts[0] != "Bearer" || len(parts[1]) == 0 {
 			app.unauthorizedResponse(w, r, errors.New("invalid authorization header"))
 			return
 		}
 
 		token := parts[1]
 
-		// Validate if the token is valid
-		jwtToken, err := app.store.Auth.VerifyToken(token, app.config.auth.jwtSecret)
+		// Bots and scripts use API keys instead of logging in
+		if store.IsAPIKey(token) {
+			app.authenticateAPIKey(w, r, next, token)
+			return
+		}
+
+		// Validate if the token is a valid access token
+		claims, err := app.store.Auth.VerifyToken(token, store.TokenTypeAccess, app.tokenConfig())
 		if err != nil {
 			app.unauthorizedResponse(w, r, errors.New("invalid token"))
 			return
 		}
 
 		// Get the user from the token
-		claims, ok := jwtToken.Claims.(jwt.MapClaims)
-		if !ok {
+		userID, err := claims.UserID()
+		if err != nil {
 			app.unauthorizedResponse(w, r, errors.New("invalid token"))
 			return
 		}
-		userID, err := strconv.ParseInt(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
+
+		ctx := r.Context()
+
+		// Reject tokens that were revoked by a logout
+		revoked, err := app.isTokenRevoked(ctx, userID, claims)
 		if err != nil {
-			app.unauthorizedResponse(w, r, errors.New("invalid token"))
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+		if revoked {
+			app.unauthorizedResponse(w, r, errors.New("token has been revoked"))
 			return
 		}
 
-		ctx := r.Context()
+		// Keep the session's last used time current, this is only bookkeeping
+		if claims.SessionID != "" {
+			err = app.store.Sessions.Touch(ctx, claims.SessionID, clientIP(r))
+			if err != nil {
+				log.Printf("touching session %s: %v", claims.SessionID, err)
+			}
+		}
 
 		// Fetch the user
-		user, err := app.store.User.GetUserByID(ctx, int(userID))
+		user, err := app.store.User.GetUserByID(ctx, userID)
 		if err != nil {
 			app.unauthorizedResponse(w, r, errors.New("invalid user"))
 			return
 		}
 
 		ctx = context.WithValue(ctx, userCtx, user)
+		ctx = context.WithValue(ctx, tokenCtx, claims)
 
 		// If all checks pass the user is allowed to go through
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// authenticateAPIKey lets a request made with an API key through as the key's
+// owner, with their role capped to what the key's scope allows
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plain string) {
+	ctx := r.Context()
+
+	key, err := app.store.APIKeys.Authenticate(ctx, plain)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidAPIKey):
+			// The prefix is enough to trace a leaked key without logging the secret
+			log.Printf("rejected api key %s from %s", store.APIKeyPrefix(plain), clientIP(r))
+			app.unauthorizedResponse(w, r, err)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.store.APIKeys.Touch(ctx, key.ID, clientIP(r))
+	if err != nil {
+		log.Printf("touching api key %s: %v", key.Prefix, err)
+	}
+
+	user, err := app.store.User.GetUserByID(ctx, key.UserID)
+	if err != nil {
+		app.unauthorizedResponse(w, r, errors.New("invalid user"))
+		return
+	}
+
+	if !key.Scope.Role().AtLeast(user.Role) {
+		user.Role = key.Scope.Role()
+	}
+
+	// Service accounts can't answer a second factor, the policy was checked
+	// when the key was issued
+	user.TwoFactorEnabled = true
+
+	ctx = context.WithValue(ctx, userCtx, user)
+	ctx = context.WithValue(ctx, apiKeyCtx, &key)
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// isTokenRevoked checks the token against single token, session and logout
+// everywhere revocations
+func (app *application) isTokenRevoked(ctx context.Context, userID int, claims *store.Claims) (bool, error) {
+	revoked, err := app.store.Revocations.IsTokenRevoked(ctx, claims.ID)
+	if err != nil || revoked {
+		return revoked, err
+	}
+
+	if claims.SessionID != "" {
+		revoked, err = app.store.Revocations.IsSessionRevoked(ctx, claims.SessionID)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	revokedAt, err := app.store.Revocations.UserTokensRevokedAt(ctx, userID)
+	if err != nil || revokedAt.IsZero() {
+		return false, err
+	}
+
+	return claims.IssuedAt.Time.Before(revokedAt), nil
+}
+
+// tokenConfig builds the settings used to sign and verify tokens
+func (app *application) tokenConfig() store.TokenConfig {
+	return store.TokenConfig{
+		Keys:     app.keys,
+		Issuer:   app.config.auth.issuer,
+		Audience: app.config.auth.audience,
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/RakibulBh/shaheed-backend/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type ApproveFlaggedRequest struct {
+	Content  *string `json:"content"`
+	Location *string `json:"location"`
+	Note     string  `json:"note"`
+}
+
+type RejectFlaggedRequest struct {
+	Note string `json:"note"`
+}
+
+func (app *application) ListFlagged(w http.ResponseWriter, r *http.Request) {
+
+	status := store.FlagStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = store.FlagStatusPending
+	}
+
+	if !status.Valid() {
+		app.badRequestResponse(w, r, errors.New("invalid status"))
+		return
+	}
+
+	ctx := r.Context()
+
+	flagged, err := app.store.Flagged.List(ctx, status)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "success", flagged)
+}
+
+func (app *application) GetFlagged(w http.ResponseWriter, r *http.Request) {
+
+	flagged, ok := app.fetchFlagged(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "success", flagged)
+}
+
+// ApproveFlagged promotes a flagged question into the questions table. When
+// content or location are sent the moderator's edited version is published.
+func (app *application) ApproveFlagged(w http.ResponseWriter, r *http.Request) {
+
+	flagged, ok := app.fetchFlagged(w, r)
+	if !ok {
+		return
+	}
+
+	// The body is optional, an empty one approves the question as is
+	var payload ApproveFlaggedRequest
+	err := app.readJSON(r, &payload)
+	if err != nil && !errors.Is(err, io.EOF) {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if flagged.Status != store.FlagStatusPending {
+		app.conflictResponse(w, r, errors.New("flagged question has already been reviewed"))
+		return
+	}
+
+	content := flagged.Content
+	if payload.Content != nil {
+		content = *payload.Content
+	}
+
+	location := flagged.Location
+	if payload.Location != nil {
+		location = *payload.Location
+	}
+
+	if content == "" {
+		app.badRequestResponse(w, r, errors.New("content cannot be empty"))
+		return
+	}
+
+	moderator := getUserFromContext(r)
+
+	ctx := r.Context()
+
+	question, err := app.store.Flagged.Approve(ctx, flagged.ID, moderator.ID, content, location, payload.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("flagged question not found"))
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, errors.New("flagged question has already been reviewed"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "question approved", question)
+}
+
+func (app *application) RejectFlagged(w http.ResponseWriter, r *http.Request) {
+
+	flagged, ok := app.fetchFlagged(w, r)
+	if !ok {
+		return
+	}
+
+	var payload RejectFlaggedRequest
+	err := app.readJSON(r, &payload)
+	if err != nil && !errors.Is(err, io.EOF) {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	moderator := getUserFromContext(r)
+
+	ctx := r.Context()
+
+	err = app.store.Flagged.Reject(ctx, flagged.ID, moderator.ID, payload.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, errors.New("flagged question has already been reviewed"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "question rejected", nil)
+}
+
+// fetchFlagged loads the flagged question named in the URL, writing the error
+// response itself when it cannot
+func (app *application) fetchFlagged(w http.ResponseWriter, r *http.Request) (*store.FlaggedQuestion, bool) {
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	flagged, err := app.store.Flagged.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("flagged question not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return flagged, true
+}
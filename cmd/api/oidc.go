@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/RakibulBh/shaheed-backend/internal/oidc"
+	"github.com/RakibulBh/shaheed-backend/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// The state is also kept in a cookie so a callback is only accepted from the
+// browser that started the login, otherwise anyone could hand a victim a
+// callback URL that signs them into the attacker's account
+const oidcStateCookie = "oidc_state"
+
+type oidcConfig struct {
+	providers []oidc.Config
+	stateTTL  time.Duration
+}
+
+// OIDCStart sends the user to the identity provider to sign in
+func (app *application) OIDCStart(w http.ResponseWriter, r *http.Request) {
+
+	name := chi.URLParam(r, "provider")
+
+	provider, ok := app.oidc[name]
+	if !ok {
+		app.notFoundResponse(w, r, errors.New("unknown identity provider"))
+		return
+	}
+
+	ctx := r.Context()
+
+	req, err := oidc.NewAuthRequest()
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	redirectURL, err := provider.AuthCodeURL(ctx, req)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.store.OIDCStates.Save(ctx, req.State, store.OIDCLoginState{
+		Provider:     name,
+		Nonce:        req.Nonce,
+		CodeVerifier: req.CodeVerifier,
+	}, app.config.oidc.stateTTL)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	cookie := &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    req.State,
+		Path:     "/v1/auth/oidc",
+		MaxAge:   int(app.config.oidc.stateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   app.config.env == "production",
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	// A Lax cookie isn't sent with the provider's cross-site form post
+	if provider.FormPost() {
+		cookie.Secure = true
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+
+	http.SetCookie(w, cookie)
+
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+// OIDCCallback handles the provider sending the user back, as a query string
+// or a form post depending on the provider. The browser is redirected to the
+// frontend with the outcome in the URL fragment, which is never sent to a server.
+func (app *application) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+
+	name := chi.URLParam(r, "provider")
+
+	provider, ok := app.oidc[name]
+	if !ok {
+		app.notFoundResponse(w, r, errors.New("unknown identity provider"))
+		return
+	}
+
+	// Each provider answers one way only, so a code can't be replayed through
+	// the other
+	if (r.Method == http.MethodPost) != provider.FormPost() {
+		app.oidcRedirect(w, r, url.Values{"error": {"invalid_request"}})
+		return
+	}
+
+	ctx := r.Context()
+
+	if errCode := r.FormValue("error"); errCode != "" {
+		app.oidcRedirect(w, r, url.Values{"error": {errCode}})
+		return
+	}
+
+	// The state has to come back to the browser it was issued to
+	cookie, err := r.Cookie(oidcStateCookie)
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/v1/auth/oidc", MaxAge: -1})
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.FormValue("state"))) != 1 {
+		app.oidcRedirect(w, r, url.Values{"error": {"invalid_state"}})
+		return
+	}
+
+	state, err := app.store.OIDCStates.Take(ctx, r.FormValue("state"))
+	if err != nil || state.Provider != name {
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Printf("oidc: loading state: %v", err)
+		}
+		app.oidcRedirect(w, r, url.Values{"error": {"invalid_state"}})
+		return
+	}
+
+	identity, err := provider.Exchange(ctx, r.FormValue("code"), oidc.AuthRequest{
+		Nonce:        state.Nonce,
+		CodeVerifier: state.CodeVerifier,
+	})
+	if err != nil {
+		log.Printf("oidc: %s login failed: %v", name, err)
+		app.oidcRedirect(w, r, url.Values{"error": {"login_failed"}})
+		return
+	}
+
+	userID, errCode, err := app.resolveIdentity(r, name, identity)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	if errCode != "" {
+		app.oidcRedirect(w, r, url.Values{"error": {errCode}})
+		return
+	}
+
+	user, err := app.store.User.GetUserDataByID(ctx, userID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	// The provider vouches for the first factor only
+	if user.TwoFactorEnabled {
+		challenge, err := app.issueChallenge(user.ID)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+
+		app.oidcRedirect(w, r, url.Values{"two_factor_required": {"true"}, "challenge_token": {challenge}})
+		return
+	}
+
+	accessToken, refreshToken, err := app.issueTokenPair(r, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.oidcRedirect(w, r, url.Values{"access_token": {accessToken}, "refresh_token": {refreshToken}})
+}
+
+// resolveIdentity finds the user linked to the provider account, linking or
+// creating one on first sign in. A non-empty code is an error for the user.
+func (app *application) resolveIdentity(r *http.Request, provider string, identity *oidc.IDToken) (int, string, error) {
+	ctx := r.Context()
+
+	userID, err := app.store.Identities.FindUser(ctx, provider, identity.Subject)
+	if err == nil {
+		return userID, "", nil
+	}
+	if !errors.Is(err, store.ErrNoRows) {
+		return 0, "", err
+	}
+
+	if identity.Email == "" {
+		return 0, "email_required", nil
+	}
+
+	existing, err := app.store.User.GetUserByEmail(ctx, identity.Email)
+	if err != nil && !errors.Is(err, store.ErrNoRows) {
+		return 0, "", err
+	}
+
+	if err == nil {
+		// Only link when both sides have proven they own the address, or
+		// whoever registered it first could take over the other account
+		if !identity.EmailVerified || existing.EmailVerifiedAt == nil {
+			return 0, "account_exists", nil
+		}
+
+		err = app.store.Identities.Link(ctx, existing.ID, provider, identity.Subject, identity.Email)
+		if err != nil {
+			return 0, "", err
+		}
+
+		return existing.ID, "", nil
+	}
+
+	firstName, lastName := identityNames(identity)
+
+	userID, err = app.store.Identities.CreateUser(ctx, store.ExternalUser{
+		FirstName:     firstName,
+		LastName:      lastName,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Locale:        app.config.mail.defaultLocale,
+		Provider:      provider,
+		Subject:       identity.Subject,
+	})
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			return 0, "account_exists", nil
+		}
+		return 0, "", err
+	}
+
+	return userID, "", nil
+}
+
+// identityNames falls back to splitting the full name, then to the email
+func identityNames(identity *oidc.IDToken) (string, string) {
+	if identity.GivenName != "" {
+		return identity.GivenName, identity.FamilyName
+	}
+
+	if first, last, ok := strings.Cut(strings.TrimSpace(identity.Name), " "); ok {
+		return first, strings.TrimSpace(last)
+	} else if first != "" {
+		return first, ""
+	}
+
+	local, _, _ := strings.Cut(identity.Email, "@")
+	return local, ""
+}
+
+func (app *application) oidcRedirect(w http.ResponseWriter, r *http.Request, values url.Values) {
+	http.Redirect(w, r, app.config.appURL+"/auth/callback#"+values.Encode(), http.StatusFound)
+}
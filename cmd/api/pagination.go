@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// readLimit reads the limit query parameter, falling back to the default page size
+func readLimit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+
+	return limit, nil
+}
+
+// readTime reads a query parameter given either as RFC 3339 or as a plain date
+func readTime(r *http.Request, key string) (time.Time, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, errors.New(key + " must be a date or an RFC 3339 timestamp")
+}
+
+// setNextLink points the Link header at the next page, keeping every other
+// query parameter of the current request
+func (app *application) setNextLink(w http.ResponseWriter, r *http.Request, cursor string) {
+	if cursor == "" {
+		return
+	}
+
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+
+	w.Header().Set("Link", fmt.Sprintf(`<%s%s?%s>; rel="next"`, app.config.apiURL, r.URL.Path, query.Encode()))
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/RakibulBh/shaheed-backend/internal/store"
+)
+
+var ErrPasswordMismatch = errors.New("passwords must match and be at least 8 characters")
+
+// validPassword checks the same rules as registration
+func validPassword(password string, confirm string) bool {
+	return len(password) >= 8 && password == confirm
+}
+
+// setPassword stores the new password, clears any lockout and signs the user
+// out everywhere so a stolen session doesn't outlive the old password
+func (app *application) setPassword(ctx context.Context, userID int, password string) error {
+	hash, err := app.store.Auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	err = app.store.User.UpdatePassword(ctx, userID, hash)
+	if err != nil {
+		return err
+	}
+
+	err = app.store.Lockout.Reset(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return app.revokeAllSessions(ctx, userID)
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ForgotPassword emails a reset link. It answers the same way whether or not
+// the account exists.
+func (app *application) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+
+	var payload ForgotPasswordRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	user, err := app.store.User.GetUserByEmail(ctx, payload.Email)
+	if err != nil && !errors.Is(err, store.ErrNoRows) {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err == nil {
+		err = app.sendPasswordResetEmail(ctx, user)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.writeJSON(w, http.StatusAccepted, "if the account exists, a password reset email has been sent", nil)
+}
+
+func (app *application) sendPasswordResetEmail(ctx context.Context, user store.UserData) error {
+	err := app.store.Tokens.DeleteForUser(ctx, user.ID, store.ScopePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	token, err := app.store.Tokens.Create(ctx, user.ID, store.ScopePasswordReset, app.config.auth.resetTTL)
+	if err != nil {
+		return err
+	}
+
+	return app.sendEmail(ctx, user.Email, user.Locale, "password_reset", map[string]any{
+		"Name":      user.FirstName,
+		"URL":       fmt.Sprintf("%s/reset-password?token=%s", app.config.appURL, token),
+		"ExpiresIn": app.config.auth.resetTTL,
+	})
+}
+
+func (app *application) sendPasswordChangedEmail(ctx context.Context, userID int) {
+	user, err := app.store.User.GetUserByID(ctx, userID)
+	if err == nil {
+		err = app.sendEmail(ctx, user.Email, user.Locale, "password_changed", map[string]any{
+			"Name": user.FirstName,
+			"URL":  fmt.Sprintf("%s/forgot-password", app.config.appURL),
+		})
+	}
+	if err != nil {
+		log.Printf("sending password changed email to user %d: %v", userID, err)
+	}
+}
+
+type ResetPasswordRequest struct {
+	Token           string `json:"token"`
+	Password        string `json:"password"`
+	PasswordConfirm string `json:"password_confirm"`
+}
+
+func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
+
+	var payload ResetPasswordRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !validPassword(payload.Password, payload.PasswordConfirm) {
+		app.badRequestResponse(w, r, ErrPasswordMismatch)
+		return
+	}
+
+	ctx := r.Context()
+
+	userID, err := app.store.Tokens.Consume(ctx, store.ScopePasswordReset, payload.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidUserToken):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.setPassword(ctx, userID, payload.Password)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendPasswordChangedEmail(ctx, userID)
+
+	app.writeJSON(w, http.StatusOK, "password reset, please log in again", nil)
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword    string `json:"current_password"`
+	NewPassword        string `json:"new_password"`
+	NewPasswordConfirm string `json:"new_password_confirm"`
+}
+
+func (app *application) ChangePassword(w http.ResponseWriter, r *http.Request) {
+
+	var payload ChangePasswordRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !validPassword(payload.NewPassword, payload.NewPasswordConfirm) {
+		app.badRequestResponse(w, r, ErrPasswordMismatch)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	ctx := r.Context()
+
+	// The context user doesn't carry the hash
+	userData, err := app.store.User.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	passwordMatches, err := app.store.Auth.VerifyPassword(payload.CurrentPassword, userData.PasswordHash)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	if !passwordMatches {
+		app.badRequestResponse(w, r, ErrInvalidCredentials)
+		return
+	}
+
+	err = app.setPassword(ctx, user.ID, payload.NewPassword)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendPasswordChangedEmail(ctx, user.ID)
+
+	app.writeJSON(w, http.StatusOK, "password changed, please log in again", nil)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/RakibulBh/shaheed-backend/internal/store"
+)
+
+var (
+	ErrForbidden       = errors.New("you do not have permission to perform this action")
+	ErrSessionRequired = errors.New("this action needs a login session, api keys are not accepted")
+)
+
+// getUserFromContext returns the user set by the Authenticate middleware
+func getUserFromContext(r *http.Request) store.User {
+	user, _ := r.Context().Value(userCtx).(store.User)
+	return user
+}
+
+// getAPIKeyFromContext returns the API key the request was made with, or nil
+// when it was made with an access token
+func getAPIKeyFromContext(r *http.Request) *store.APIKey {
+	key, _ := r.Context().Value(apiKeyCtx).(*store.APIKey)
+	return key
+}
+
+// RequireScope only lets API keys through when their scope allows at least
+// the given one. Requests made with an access token aren't affected.
+func (app *application) RequireScope(scope store.APIKeyScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := getAPIKeyFromContext(r); key != nil && !key.Scope.Allows(scope) {
+				app.forbiddenResponse(w, r, ErrForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession turns away API keys from account management, which needs
+// someone who has logged in
+func (app *application) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if getAPIKeyFromContext(r) != nil {
+			app.forbiddenResponse(w, r, ErrSessionRequired)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole only lets through authenticated users holding at least the given role
+func (app *application) RequireRole(role store.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := getUserFromContext(r)
+
+			if !user.Role.AtLeast(role) {
+				app.forbiddenResponse(w, r, ErrForbidden)
+				return
+			}
+
+			// Elevated roles may need a second factor before their powers apply
+			if !app.hasRequiredTwoFactor(user) {
+				app.forbiddenResponse(w, r, ErrTwoFactorRequired)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireVerifiedEmail blocks users who haven't confirmed their email address,
+// when the auth.requireVerifiedEmail policy is on
+func (app *application) RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.auth.requireVerifiedEmail && !getUserFromContext(r).EmailVerified() {
+			app.forbiddenResponse(w, r, ErrEmailNotVerified)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// canModify reports whether the user may change a resource owned by ownerID,
+// either because they own it or because their role is at least override
+func (app *application) canModify(user store.User, ownerID int, override store.Role) bool {
+	if user.ID == ownerID {
+		return true
+	}
+
+	return user.Role.AtLeast(override) && app.hasRequiredTwoFactor(user)
+}
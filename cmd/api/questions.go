@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -16,7 +17,7 @@ type QuestionRequest struct {
 
 func (app *application) PostQuestion(w http.ResponseWriter, r *http.Request) {
 
-	user := r.Context().Value(userCtx).(store.User)
+	user := getUserFromContext(r)
 
 	var questionRequest QuestionRequest
 	err := app.readJSON(r, &questionRequest)
@@ -31,16 +32,28 @@ func (app *application) PostQuestion(w http.ResponseWriter, r *http.Request) {
 	var parentID int
 	if questionRequest.ParentID != nil {
 		parentID = *questionRequest.ParentID
+
+		// Replies must point at an existing question or reply
+		_, err = app.store.Questions.GetByID(ctx, parentID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.badRequestResponse(w, r, errors.New("parent question does not exist"))
+			default:
+				app.internalServerErrorResponse(w, r, err)
+			}
+			return
+		}
 	}
 
 	// Verify if the content should be flagged
-	flagged, reason, err := app.store.Questions.VerifyContent(ctx, questionRequest.Content, app.config.llm.model, app.config.llm.apiKey)
+	verdict, err := app.moderator.Moderate(ctx, questionRequest.Content)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
 	}
 
-	if !flagged {
+	if !verdict.Flagged {
 		question, err := app.store.Questions.Create(ctx, user.ID, questionRequest.Content, parentID, questionRequest.Location)
 		if err != nil {
 			app.internalServerErrorResponse(w, r, err)
@@ -51,28 +64,83 @@ func (app *application) PostQuestion(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Content was flagged so add to the flagged table
-	err = app.store.Questions.FlagQuestion(ctx, user.ID, questionRequest.Content, parentID, questionRequest.Location, reason)
+	err = app.store.Questions.FlagQuestion(ctx, user.ID, questionRequest.Content, parentID, questionRequest.Location, verdict.Reason)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusUnprocessableEntity, "question flagged", reason)
+	app.writeJSON(w, http.StatusUnprocessableEntity, "question flagged", verdict.Reason)
 }
 
 func (app *application) GetQuestions(w http.ResponseWriter, r *http.Request) {
 
+	filter, err := readQuestionFilter(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
 	ctx := r.Context()
 
-	questions, err := app.store.Questions.GetQuestions(ctx)
+	questions, next, err := app.store.Questions.GetQuestions(ctx, filter)
 	if err != nil {
-		app.internalServerErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, store.ErrInvalidCursor):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
 		return
 	}
 
+	app.setNextLink(w, r, next)
 	app.writeJSON(w, http.StatusOK, "success", questions)
 }
 
+// readQuestionFilter reads the paging, sorting and filtering query parameters
+func readQuestionFilter(r *http.Request) (store.QuestionFilter, error) {
+	query := r.URL.Query()
+
+	filter := store.QuestionFilter{
+		Sort:     store.QuestionSort(query.Get("sort")),
+		Cursor:   query.Get("cursor"),
+		Location: query.Get("location"),
+	}
+
+	if filter.Sort == "" {
+		filter.Sort = store.SortNewest
+	}
+	if !filter.Sort.Valid() {
+		return filter, errors.New("sort must be one of newest, oldest, answers or votes")
+	}
+
+	limit, err := readLimit(r)
+	if err != nil {
+		return filter, err
+	}
+	filter.Limit = limit
+
+	if author := query.Get("author"); author != "" {
+		filter.UserID, err = strconv.Atoi(author)
+		if err != nil {
+			return filter, errors.New("author must be a user id")
+		}
+	}
+
+	filter.From, err = readTime(r, "from")
+	if err != nil {
+		return filter, err
+	}
+
+	filter.To, err = readTime(r, "to")
+	if err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
 func (app *application) GetQuestion(w http.ResponseWriter, r *http.Request) {
 
 	id := chi.URLParam(r, "id")
@@ -95,6 +163,87 @@ func (app *application) GetQuestion(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusOK, "success", question)
 }
 
+func (app *application) GetAnswers(w http.ResponseWriter, r *http.Request) {
+
+	id := chi.URLParam(r, "id")
+
+	ctx := r.Context()
+
+	questionID, err := strconv.Atoi(id)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Make sure the question exists before walking its replies
+	_, err = app.store.Questions.Get(ctx, questionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("question not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	answers, err := app.store.Questions.GetAnswers(ctx, questionID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "success", answers)
+}
+
+type VoteRequest struct {
+	Value int `json:"value"`
+}
+
+func (app *application) VoteQuestion(w http.ResponseWriter, r *http.Request) {
+
+	id := chi.URLParam(r, "id")
+
+	questionID, err := strconv.Atoi(id)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload VoteRequest
+	err = app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Value < -1 || payload.Value > 1 {
+		app.badRequestResponse(w, r, errors.New("value must be -1, 0 or 1"))
+		return
+	}
+
+	ctx := r.Context()
+
+	_, err = app.store.Questions.GetByID(ctx, questionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("question not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.store.Questions.Vote(ctx, questionID, getUserFromContext(r).ID, payload.Value)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "vote recorded", nil)
+}
+
 func (app *application) UpdateQuestion(w http.ResponseWriter, r *http.Request) {
 
 	id := chi.URLParam(r, "id")
@@ -114,11 +263,26 @@ func (app *application) UpdateQuestion(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 
-	question := &store.Question{
-		ID:       questionID,
-		Content:  questionRequest.Content,
-		Location: questionRequest.Location,
+	question, err := app.store.Questions.GetByID(ctx, questionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("question not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
 	}
+
+	// Only the author or a moderator may edit a question
+	if !app.canModify(getUserFromContext(r), question.UserID, store.RoleModerator) {
+		app.forbiddenResponse(w, r, ErrForbidden)
+		return
+	}
+
+	question.Content = questionRequest.Content
+	question.Location = questionRequest.Location
+
 	err = app.store.Questions.Update(ctx, question)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
@@ -140,6 +304,23 @@ func (app *application) DeleteQuestion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	question, err := app.store.Questions.GetByID(ctx, questionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("question not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only the author or a moderator may delete a question
+	if !app.canModify(getUserFromContext(r), question.UserID, store.RoleModerator) {
+		app.forbiddenResponse(w, r, ErrForbidden)
+		return
+	}
+
 	err = app.store.Questions.Delete(ctx, questionID)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
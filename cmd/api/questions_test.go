@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RakibulBh/shaheed-backend/internal/moderation"
+	"github.com/RakibulBh/shaheed-backend/internal/store"
+)
+
+// fakeQuestions records what PostQuestion stores. Methods it doesn't override
+// hit the embedded nil store and panic.
+type fakeQuestions struct {
+	*store.QuestionStore
+
+	created []string
+	flagged []string
+}
+
+func (f *fakeQuestions) Create(ctx context.Context, userID int, content string, parentID int, location string) (*store.Question, error) {
+	f.created = append(f.created, content)
+	return &store.Question{ID: len(f.created), Content: content, UserID: userID}, nil
+}
+
+func (f *fakeQuestions) FlagQuestion(ctx context.Context, userID int, content string, parentID int, location string, reason string) error {
+	f.flagged = append(f.flagged, reason)
+	return nil
+}
+
+func TestPostQuestion(t *testing.T) {
+	tests := []struct {
+		name       string
+		moderator  *moderation.Fake
+		wantStatus int
+		wantCreate int
+		wantFlag   int
+	}{
+		{
+			name:       "published",
+			moderator:  &moderation.Fake{},
+			wantStatus: http.StatusOK,
+			wantCreate: 1,
+		},
+		{
+			name:       "flagged",
+			moderator:  &moderation.Fake{Verdict: moderation.Verdict{Flagged: true, Reason: "not a question"}},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFlag:   1,
+		},
+		{
+			name:       "moderator unavailable",
+			moderator:  &moderation.Fake{Err: errors.New("timed out")},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			questions := &fakeQuestions{}
+			app := &application{
+				store:     store.Storage{Questions: questions},
+				moderator: tt.moderator,
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/v1/questions", strings.NewReader(`{"content":"Is it permissible to pray sitting down?","location":"London"}`))
+			r = r.WithContext(context.WithValue(r.Context(), userCtx, store.User{ID: 7}))
+			w := httptest.NewRecorder()
+
+			app.PostQuestion(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if len(questions.created) != tt.wantCreate {
+				t.Errorf("created %d questions, want %d", len(questions.created), tt.wantCreate)
+			}
+			if len(questions.flagged) != tt.wantFlag {
+				t.Errorf("flagged %d questions, want %d", len(questions.flagged), tt.wantFlag)
+			}
+			if calls := tt.moderator.Calls(); len(calls) != 1 || calls[0] != "Is it permissible to pray sitting down?" {
+				t.Errorf("moderator calls = %q", calls)
+			}
+		})
+	}
+}
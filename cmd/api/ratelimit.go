@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rateLimitPolicy is the number of requests allowed per window for one group of routes
+type rateLimitPolicy struct {
+	name   string
+	limit  int
+	window time.Duration
+}
+
+// RateLimit limits requests per user when authenticated and per IP otherwise.
+// It must run after Authenticate to count authenticated users separately.
+func (app *application) RateLimit(policy rateLimitPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.config.rateLimit.enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := fmt.Sprintf("ratelimit:%s:ip:%s", policy.name, clientIP(r))
+			if user := getUserFromContext(r); user.ID != 0 {
+				key = fmt.Sprintf("ratelimit:%s:user:%d", policy.name, user.ID)
+			}
+
+			result, err := app.limiter.Allow(r.Context(), key, policy.limit, policy.window)
+			if err != nil {
+				app.internalServerErrorResponse(w, r, err)
+				return
+			}
+
+			resetSeconds := strconv.Itoa(int(math.Ceil(result.ResetAfter.Seconds())))
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("X-RateLimit-Reset", resetSeconds)
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", resetSeconds)
+				app.rateLimitExceededResponse(w, r, errors.New("rate limit exceeded, try again later"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIP returns the client address, already resolved by the RealIP middleware
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func (app *application) Search(w http.ResponseWriter, r *http.Request) {
+
+	text := strings.TrimSpace(r.URL.Query().Get("q"))
+	if text == "" {
+		app.badRequestResponse(w, r, errors.New("q is required"))
+		return
+	}
+
+	limit, err := readLimit(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	offset := 0
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			app.badRequestResponse(w, r, errors.New("offset cannot be negative"))
+			return
+		}
+	}
+
+	ctx := r.Context()
+
+	results, err := app.store.Questions.Search(ctx, text, limit, offset)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "success", results)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RakibulBh/shaheed-backend/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Clients can name the device they log in from, otherwise one is made up from the user agent
+const deviceLabelHeader = "X-Device-Label"
+
+// requestDevice describes the device a login request came from
+func requestDevice(r *http.Request) store.Device {
+	userAgent := r.UserAgent()
+
+	label := strings.TrimSpace(r.Header.Get(deviceLabelHeader))
+	if len(label) > 100 {
+		label = label[:100]
+	}
+	if label == "" {
+		label = deviceLabel(userAgent)
+	}
+
+	return store.Device{
+		UserAgent: userAgent,
+		IP:        clientIP(r),
+		Label:     label,
+	}
+}
+
+// deviceLabel turns a user agent into something like "Firefox on Windows".
+// Order matters, most browsers claim to be several others as well.
+func deviceLabel(userAgent string) string {
+	browser := ""
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"SamsungBrowser/", "Samsung Internet"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"okhttp/", "Android app"},
+		{"Dart/", "Mobile app"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	os := ""
+	for _, o := range []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			os = o.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	default:
+		return "Unknown device"
+	}
+}
+
+// revokeSession ends the session and cuts off the access tokens issued in it
+func (app *application) revokeSession(ctx context.Context, userID int, sessionID string) error {
+	err := app.store.Sessions.Revoke(ctx, userID, sessionID)
+	if err != nil {
+		return err
+	}
+
+	return app.store.Revocations.RevokeSession(ctx, sessionID, app.config.auth.exp)
+}
+
+func (app *application) ListSessions(w http.ResponseWriter, r *http.Request) {
+
+	user := getUserFromContext(r)
+	claims := r.Context().Value(tokenCtx).(*store.Claims)
+
+	sessions, err := app.store.Sessions.List(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.SessionID
+	}
+
+	app.writeJSON(w, http.StatusOK, "sessions fetched", sessions)
+}
+
+func (app *application) RevokeSession(w http.ResponseWriter, r *http.Request) {
+
+	id := chi.URLParam(r, "id")
+
+	if _, err := uuid.Parse(id); err != nil {
+		app.notFoundResponse(w, r, errors.New("session not found"))
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	err := app.revokeSession(r.Context(), user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("session not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "session revoked", nil)
+}
+
+// hashStoredRefreshTokens converts refresh tokens stored in the clear by
+// earlier versions, so a database leak doesn't hand out live credentials
+func (app *application) hashStoredRefreshTokens(ctx context.Context) error {
+	hashed, err := app.store.Auth.HashStoredRefreshTokens(ctx)
+	if err != nil {
+		return err
+	}
+
+	if hashed > 0 {
+		log.Printf("hashed %d stored refresh tokens", hashed)
+	}
+
+	return nil
+}
+
+// purgeRefreshTokens periodically deletes refresh tokens and sessions that
+// have been expired for longer than the retention period
+func (app *application) purgeRefreshTokens(ctx context.Context) {
+	ticker := time.NewTicker(app.config.auth.tokenPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := app.store.Auth.PurgeExpiredRefreshTokens(ctx, time.Now().Add(-app.config.auth.tokenRetention))
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("purging expired refresh tokens: %v", err)
+				}
+				continue
+			}
+			if purged > 0 {
+				log.Printf("purged %d expired refresh tokens", purged)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/RakibulBh/shaheed-backend/internal/store"
+	"github.com/RakibulBh/shaheed-backend/internal/textnorm"
+	"github.com/go-chi/chi/v5"
+)
+
+type SynonymRequest struct {
+	Term      string `json:"term"`
+	Canonical string `json:"canonical"`
+}
+
+func (app *application) ListSynonyms(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	synonyms, err := app.store.Synonyms.List(ctx)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "success", synonyms)
+}
+
+func (app *application) PutSynonym(w http.ResponseWriter, r *http.Request) {
+
+	var payload SynonymRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Terms are stored folded so lookups match however they were typed in
+	term := textnorm.Fold(strings.TrimSpace(payload.Term))
+	canonical := textnorm.Fold(strings.TrimSpace(payload.Canonical))
+
+	if term == "" || canonical == "" || strings.ContainsFunc(term+canonical, unicode.IsSpace) {
+		app.badRequestResponse(w, r, errors.New("term and canonical must be single words"))
+		return
+	}
+
+	if term == canonical {
+		app.badRequestResponse(w, r, errors.New("term and canonical must differ"))
+		return
+	}
+
+	ctx := r.Context()
+
+	err = app.store.Synonyms.Upsert(ctx, term, canonical)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.loadSynonyms(ctx)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "synonym saved", store.Synonym{Term: term, Canonical: canonical})
+}
+
+func (app *application) DeleteSynonym(w http.ResponseWriter, r *http.Request) {
+
+	term := textnorm.Fold(chi.URLParam(r, "term"))
+
+	ctx := r.Context()
+
+	err := app.store.Synonyms.Delete(ctx, term)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("synonym not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.loadSynonyms(ctx)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "synonym deleted", nil)
+}
+
+// ReindexSearch recomputes the search text of every question, so dictionary
+// changes apply to content posted before them
+func (app *application) ReindexSearch(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	updated, err := app.store.Questions.Reindex(ctx)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "search reindexed", envelope{"updated": updated})
+}
+
+// refreshSynonyms periodically reloads the dictionary so edits made through
+// another instance are picked up
+func (app *application) refreshSynonyms(ctx context.Context) {
+	ticker := time.NewTicker(app.config.search.synonymRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := app.loadSynonyms(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("reloading search synonyms: %v", err)
+			}
+		}
+	}
+}
+
+// loadSynonyms refreshes the normaliser's dictionary from the database
+func (app *application) loadSynonyms(ctx context.Context) error {
+	synonyms, err := app.store.Synonyms.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	dictionary := make(map[string]string, len(synonyms))
+	for _, synonym := range synonyms {
+		dictionary[synonym.Term] = synonym.Canonical
+	}
+
+	app.normalizer.SetSynonyms(dictionary)
+
+	return nil
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RakibulBh/shaheed-backend/internal/store"
+	"github.com/RakibulBh/shaheed-backend/internal/totp"
+)
+
+var (
+	ErrTwoFactorRequired = errors.New("your role requires two-factor authentication, enable it to continue")
+	ErrInvalidCode       = errors.New("invalid two-factor code")
+)
+
+const recoveryCodeCount = 10
+
+// rolePolicies caches which roles must use two-factor authentication, so the
+// check in RequireRole doesn't cost a query per request
+type rolePolicies struct {
+	mu        sync.RWMutex
+	twoFactor map[store.Role]bool
+}
+
+func (p *rolePolicies) requireTwoFactor(role store.Role) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.twoFactor[role]
+}
+
+// loadRolePolicies refreshes the cached policies from the database
+func (app *application) loadRolePolicies(ctx context.Context) error {
+	policies, err := app.store.TwoFactor.ListPolicies(ctx)
+	if err != nil {
+		return err
+	}
+
+	twoFactor := make(map[store.Role]bool, len(policies))
+	for _, policy := range policies {
+		twoFactor[policy.Role] = policy.RequireTwoFactor
+	}
+
+	app.policies.mu.Lock()
+	app.policies.twoFactor = twoFactor
+	app.policies.mu.Unlock()
+
+	return nil
+}
+
+// refreshRolePolicies picks up policy changes made through another instance
+func (app *application) refreshRolePolicies(ctx context.Context) {
+	ticker := time.NewTicker(app.config.auth.policyRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := app.loadRolePolicies(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("reloading role policies: %v", err)
+			}
+		}
+	}
+}
+
+// hasRequiredTwoFactor reports whether the user meets their role's two-factor policy
+func (app *application) hasRequiredTwoFactor(user store.User) bool {
+	return user.TwoFactorEnabled || !app.policies.requireTwoFactor(user.Role)
+}
+
+// verifySecondFactor checks a TOTP code, or a recovery code when one is given
+func (app *application) verifySecondFactor(ctx context.Context, userID int, code string, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		return app.store.TwoFactor.UseRecoveryCode(ctx, userID, recoveryCode)
+	}
+
+	tf, err := app.store.TwoFactor.Get(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	if !tf.Enabled() {
+		return false, nil
+	}
+
+	step, ok := totp.Validate(tf.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// A code can only be used once
+	return app.store.TwoFactor.UseStep(ctx, userID, step)
+}
+
+// generateRecoveryCodes returns single use codes formatted like abcde-fghij
+func generateRecoveryCodes() ([]string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	return codes, nil
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+// LoginTwoFactor finishes a login started with a password by checking the second factor
+func (app *application) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	var payload TwoFactorLoginRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	ip := clientIP(r)
+
+	if app.loginBlocked(ctx, ip) {
+		app.rateLimitExceededResponse(w, r, errors.New("too many failed login attempts, try again later"))
+		return
+	}
+
+	claims, err := app.store.Auth.VerifyToken(payload.ChallengeToken, store.TokenTypeChallenge, app.tokenConfig())
+	if err != nil {
+		app.unauthorizedResponse(w, r, errors.New("invalid challenge token"))
+		return
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		app.unauthorizedResponse(w, r, errors.New("invalid challenge token"))
+		return
+	}
+
+	// Each challenge completes one login
+	revoked, err := app.store.Revocations.IsTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	if revoked {
+		app.unauthorizedResponse(w, r, errors.New("challenge token has already been used"))
+		return
+	}
+
+	user, err := app.store.User.GetUserDataByID(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRows):
+			app.unauthorizedResponse(w, r, errors.New("invalid challenge token"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ok := false
+	if !user.IsLocked() {
+		ok, err = app.verifySecondFactor(ctx, user.ID, payload.Code, payload.RecoveryCode)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Wrong codes count towards the same lockout as wrong passwords
+	if !ok {
+		account := &user
+		if user.IsLocked() {
+			account = nil
+		}
+
+		err = app.loginFailed(ctx, ip, account)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+		app.badRequestResponse(w, r, ErrInvalidCode)
+		return
+	}
+
+	err = app.store.Revocations.RevokeToken(ctx, claims.ID, time.Until(claims.ExpiresAt.Time))
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// EnrollTwoFactor creates a new secret for the user to add to their authenticator app
+func (app *application) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	user := getUserFromContext(r)
+
+	if user.TwoFactorEnabled {
+		app.conflictResponse(w, r, errors.New("two-factor authentication is already enabled"))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.store.TwoFactor.SetPendingSecret(r.Context(), user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, errors.New("two-factor authentication is already enabled"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The URI is what goes in the QR code
+	app.writeJSON(w, http.StatusOK, "scan the code with your authenticator app and confirm it", map[string]string{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(app.config.auth.totpIssuer, user.Email, secret),
+	})
+}
+
+type ConfirmTwoFactorRequest struct {
+	Code string `json:"code"`
+}
+
+// ConfirmTwoFactor turns two-factor on once the user proves their app works
+func (app *application) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	var payload ConfirmTwoFactorRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	ctx := r.Context()
+
+	tf, err := app.store.TwoFactor.Get(ctx, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	if tf.Enabled() {
+		app.conflictResponse(w, r, errors.New("two-factor authentication is already enabled"))
+		return
+	}
+
+	if tf.Secret == "" {
+		app.badRequestResponse(w, r, errors.New("start enrolment first"))
+		return
+	}
+
+	step, ok := totp.Validate(tf.Secret, payload.Code, time.Now())
+	if !ok {
+		app.badRequestResponse(w, r, ErrInvalidCode)
+		return
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.store.TwoFactor.Enable(ctx, user.ID, step, codes)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, errors.New("two-factor authentication is already enabled"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Recovery codes are only ever shown here
+	app.writeJSON(w, http.StatusOK, "two-factor authentication enabled, store your recovery codes somewhere safe", map[string]any{
+		"recovery_codes": codes,
+	})
+}
+
+type DisableTwoFactorRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (app *application) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	var payload DisableTwoFactorRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	if app.policies.requireTwoFactor(user.Role) {
+		app.forbiddenResponse(w, r, errors.New("your role requires two-factor authentication"))
+		return
+	}
+
+	ctx := r.Context()
+
+	userData, err := app.store.User.GetUserDataByID(ctx, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	passwordMatches, err := app.store.Auth.VerifyPassword(payload.Password, userData.PasswordHash)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	if !passwordMatches {
+		app.badRequestResponse(w, r, ErrInvalidCredentials)
+		return
+	}
+
+	ok, err := app.verifySecondFactor(ctx, user.ID, payload.Code, payload.RecoveryCode)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.badRequestResponse(w, r, ErrInvalidCode)
+		return
+	}
+
+	err = app.store.TwoFactor.Disable(ctx, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "two-factor authentication disabled", nil)
+}
+
+func (app *application) ListRolePolicies(w http.ResponseWriter, r *http.Request) {
+
+	policies, err := app.store.TwoFactor.ListPolicies(r.Context())
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "role policies fetched", policies)
+}
+
+type RolePolicyRequest struct {
+	Role             store.Role `json:"role"`
+	RequireTwoFactor bool       `json:"require_two_factor"`
+}
+
+func (app *application) PutRolePolicy(w http.ResponseWriter, r *http.Request) {
+
+	var payload RolePolicyRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !payload.Role.Valid() {
+		app.badRequestResponse(w, r, errors.New("invalid role"))
+		return
+	}
+
+	ctx := r.Context()
+
+	err = app.store.TwoFactor.SetPolicy(ctx, payload.Role, payload.RequireTwoFactor)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	// Apply it here straight away, other instances catch up on their next refresh
+	err = app.loadRolePolicies(ctx)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "role policy updated", nil)
+}
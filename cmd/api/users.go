@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/RakibulBh/shaheed-backend/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type UpdateRoleRequest struct {
+	Role store.Role `json:"role"`
+}
+
+func (app *application) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+
+	id := chi.URLParam(r, "id")
+
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload UpdateRoleRequest
+	err = app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !payload.Role.Valid() {
+		app.badRequestResponse(w, r, errors.New("invalid role"))
+		return
+	}
+
+	// Admins cannot demote themselves and lock everyone out
+	if getUserFromContext(r).ID == userID {
+		app.badRequestResponse(w, r, errors.New("you cannot change your own role"))
+		return
+	}
+
+	ctx := r.Context()
+
+	err = app.store.User.UpdateRole(ctx, userID, payload.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRows):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "role updated", nil)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/RakibulBh/shaheed-backend/internal/store"
+)
+
+var ErrEmailNotVerified = errors.New("you must verify your email address first")
+
+// sendVerificationEmail replaces any earlier verification token with a new one and emails it
+func (app *application) sendVerificationEmail(ctx context.Context, user store.User) error {
+	err := app.store.Tokens.DeleteForUser(ctx, user.ID, store.ScopeEmailVerification)
+	if err != nil {
+		return err
+	}
+
+	token, err := app.store.Tokens.Create(ctx, user.ID, store.ScopeEmailVerification, app.config.auth.verificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return app.sendEmail(ctx, user.Email, user.Locale, "verify_email", map[string]any{
+		"Name":      user.FirstName,
+		"URL":       fmt.Sprintf("%s/verify-email?token=%s", app.config.appURL, token),
+		"ExpiresIn": app.config.auth.verificationTTL,
+	})
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+func (app *application) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+
+	var payload VerifyEmailRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	userID, err := app.store.Tokens.Consume(ctx, store.ScopeEmailVerification, payload.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidUserToken):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.store.User.MarkEmailVerified(ctx, userID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "email verified", nil)
+}
+
+func (app *application) ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+
+	user := getUserFromContext(r)
+
+	if user.EmailVerified() {
+		app.conflictResponse(w, r, errors.New("email is already verified"))
+		return
+	}
+
+	err := app.sendVerificationEmail(r.Context(), user)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusAccepted, "verification email sent", nil)
+}
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS questions (
+    id BIGSERIAL PRIMARY KEY,
+    content TEXT NOT NULL,
+    location TEXT NOT NULL DEFAULT '',
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    parent_id BIGINT REFERENCES questions (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_questions_parent_id ON questions (parent_id);
+CREATE INDEX IF NOT EXISTS idx_questions_created_at ON questions (created_at DESC) WHERE parent_id IS NULL;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS flagged_questions (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    parent_id BIGINT REFERENCES questions (id) ON DELETE SET NULL,
+    location TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
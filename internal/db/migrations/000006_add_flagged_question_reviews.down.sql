@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_flagged_questions_status;
+
+ALTER TABLE flagged_questions
+    DROP COLUMN IF EXISTS question_id,
+    DROP COLUMN IF EXISTS review_note,
+    DROP COLUMN IF EXISTS reviewed_at,
+    DROP COLUMN IF EXISTS reviewed_by,
+    DROP COLUMN IF EXISTS status;
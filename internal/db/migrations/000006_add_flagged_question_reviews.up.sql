@@ -0,0 +1,8 @@
+ALTER TABLE flagged_questions
+    ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    ADD COLUMN IF NOT EXISTS reviewed_by BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS review_note TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS question_id BIGINT REFERENCES questions (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_flagged_questions_status ON flagged_questions (status, created_at);
@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS idx_questions_user_id;
+DROP INDEX IF EXISTS idx_questions_votes;
+DROP INDEX IF EXISTS idx_questions_answers;
+DROP INDEX IF EXISTS idx_questions_newest;
+
+DROP TRIGGER IF EXISTS question_votes_score ON question_votes;
+DROP FUNCTION IF EXISTS question_votes_update_score();
+DROP TRIGGER IF EXISTS questions_answer_count ON questions;
+DROP FUNCTION IF EXISTS questions_update_answer_count();
+
+DROP TABLE IF EXISTS question_votes;
+
+ALTER TABLE questions
+    DROP COLUMN IF EXISTS vote_score,
+    DROP COLUMN IF EXISTS answer_count;
@@ -0,0 +1,52 @@
+ALTER TABLE questions
+    ADD COLUMN IF NOT EXISTS answer_count INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS vote_score INT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS question_votes (
+    question_id BIGINT NOT NULL REFERENCES questions (id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    value SMALLINT NOT NULL CHECK (value IN (-1, 1)),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (question_id, user_id)
+);
+
+UPDATE questions q
+SET answer_count = (SELECT COUNT(*) FROM questions c WHERE c.parent_id = q.id);
+
+-- Keep the number of direct replies on each question up to date
+CREATE OR REPLACE FUNCTION questions_update_answer_count() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' AND NEW.parent_id IS NOT NULL THEN
+        UPDATE questions SET answer_count = answer_count + 1 WHERE id = NEW.parent_id;
+    ELSIF TG_OP = 'DELETE' AND OLD.parent_id IS NOT NULL THEN
+        UPDATE questions SET answer_count = answer_count - 1 WHERE id = OLD.parent_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER questions_answer_count
+AFTER INSERT OR DELETE ON questions
+FOR EACH ROW EXECUTE FUNCTION questions_update_answer_count();
+
+-- Keep the sum of votes on each question up to date
+CREATE OR REPLACE FUNCTION question_votes_update_score() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE questions SET vote_score = vote_score - OLD.value WHERE id = OLD.question_id;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE questions SET vote_score = vote_score + NEW.value WHERE id = NEW.question_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER question_votes_score
+AFTER INSERT OR UPDATE OR DELETE ON question_votes
+FOR EACH ROW EXECUTE FUNCTION question_votes_update_score();
+
+CREATE INDEX IF NOT EXISTS idx_questions_newest ON questions (created_at DESC, id DESC) WHERE parent_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_questions_answers ON questions (answer_count DESC, id DESC) WHERE parent_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_questions_votes ON questions (vote_score DESC, id DESC) WHERE parent_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_questions_user_id ON questions (user_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_questions_search_vector;
+
+ALTER TABLE questions DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,7 @@
+-- English and Arabic stemming are combined so either language matches
+ALTER TABLE questions
+    ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+        to_tsvector('english', content) || to_tsvector('arabic', content)
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_questions_search_vector ON questions USING GIN (search_vector);
@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS idx_questions_search_vector;
+ALTER TABLE questions DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE questions DROP COLUMN IF EXISTS search_content;
+
+ALTER TABLE questions
+    ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+        to_tsvector('english', content) || to_tsvector('arabic', content)
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_questions_search_vector ON questions USING GIN (search_vector);
+
+DROP TABLE IF EXISTS search_synonyms;
@@ -0,0 +1,48 @@
+CREATE TABLE IF NOT EXISTS search_synonyms (
+    term TEXT PRIMARY KEY,
+    canonical TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO search_synonyms (term, canonical) VALUES
+    ('salat', 'salah'),
+    ('salaat', 'salah'),
+    ('salaah', 'salah'),
+    ('namaz', 'salah'),
+    ('namaaz', 'salah'),
+    ('صلاة', 'salah'),
+    ('wudhu', 'wudu'),
+    ('wudoo', 'wudu'),
+    ('wuzu', 'wudu'),
+    ('ablution', 'wudu'),
+    ('وضوء', 'wudu'),
+    ('ramazan', 'ramadan'),
+    ('ramadhan', 'ramadan'),
+    ('رمضان', 'ramadan'),
+    ('koran', 'quran'),
+    ('quraan', 'quran'),
+    ('qur''an', 'quran'),
+    ('قرآن', 'quran'),
+    ('zakah', 'zakat'),
+    ('zakaat', 'zakat'),
+    ('زكاة', 'zakat'),
+    ('haj', 'hajj'),
+    ('حج', 'hajj'),
+    ('sawm', 'fasting'),
+    ('siyam', 'fasting'),
+    ('roza', 'fasting'),
+    ('صوم', 'fasting')
+ON CONFLICT (term) DO NOTHING;
+
+-- The search vector is now built from text normalised by the application,
+-- falling back to the raw content until a row has been reindexed
+DROP INDEX IF EXISTS idx_questions_search_vector;
+ALTER TABLE questions DROP COLUMN IF EXISTS search_vector;
+
+ALTER TABLE questions ADD COLUMN IF NOT EXISTS search_content TEXT;
+ALTER TABLE questions
+    ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+        to_tsvector('english', COALESCE(search_content, content)) || to_tsvector('arabic', COALESCE(search_content, content))
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_questions_search_vector ON questions USING GIN (search_vector);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS user_tokens;
+
+DROP INDEX IF EXISTS idx_users_locked_until;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS locked_until,
+    DROP COLUMN IF EXISTS failed_login_attempts;
@@ -0,0 +1,5 @@
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
+
+DROP INDEX IF EXISTS idx_mail_outbox_pending;
+
+DROP TABLE IF EXISTS mail_outbox;
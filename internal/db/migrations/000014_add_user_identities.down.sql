@@ -0,0 +1,6 @@
+-- An empty hash never matches, those users will have to reset their password
+UPDATE users SET password_hash = '' WHERE password_hash IS NULL;
+
+ALTER TABLE users ALTER COLUMN password_hash SET NOT NULL;
+
+DROP TABLE IF EXISTS user_identities;
@@ -0,0 +1,3 @@
+-- Hash of a secret held by the device that asked for the token. When set the
+-- token can only be redeemed by presenting that secret.
+ALTER TABLE user_tokens ADD COLUMN IF NOT EXISTS binding_hash TEXT;
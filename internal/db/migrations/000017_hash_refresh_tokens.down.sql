@@ -0,0 +1,8 @@
+-- Hashed tokens can't be turned back into tokens, those sessions end
+DELETE FROM refresh_tokens WHERE token IS NULL;
+
+DROP INDEX IF EXISTS idx_refresh_tokens_expires_at;
+
+ALTER TABLE refresh_tokens
+    ALTER COLUMN token SET NOT NULL,
+    DROP COLUMN IF EXISTS token_hash;
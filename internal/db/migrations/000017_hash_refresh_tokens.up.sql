@@ -0,0 +1,8 @@
+-- Refresh tokens are stored as an HMAC keyed with a server side pepper. The
+-- raw token column is kept until the API has hashed existing rows on startup
+-- and is left NULL for every new token.
+ALTER TABLE refresh_tokens
+    ADD COLUMN IF NOT EXISTS token_hash TEXT UNIQUE,
+    ALTER COLUMN token DROP NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires_at ON refresh_tokens (expires_at);
@@ -0,0 +1,214 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKey     = errors.New("unknown signing key")
+	ErrMethodMismatch = errors.New("token signing method does not match key")
+)
+
+// Key is a single key used to sign or verify tokens
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private any
+	Public  any
+}
+
+// CanSign reports whether the key holds a private part
+func (k *Key) CanSign() bool {
+	return k.Private != nil
+}
+
+// KeySet holds every key we accept tokens from, identified by kid, along with
+// the one key new tokens are signed with. Keeping retired keys around for
+// verification is what lets us rotate without logging everyone out.
+type KeySet struct {
+	keys    map[string]*Key
+	signing *Key
+}
+
+// NewHMAC returns a key set with a single shared secret. Tokens signed with it
+// carry no kid.
+func NewHMAC(secret string) *KeySet {
+	key := &Key{
+		Method:  jwt.SigningMethodHS256,
+		Private: []byte(secret),
+		Public:  []byte(secret),
+	}
+
+	return &KeySet{
+		keys:    map[string]*Key{"": key},
+		signing: key,
+	}
+}
+
+// Load reads every PEM file in dir into a key set. The file name without its
+// extension is used as the kid. Private keys can sign and verify, public keys
+// only verify and are meant for keys that are being retired. New tokens are
+// signed with signingKID, which may be left empty when the directory holds a
+// single private key.
+func Load(dir string, signingKID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	ks := &KeySet{keys: map[string]*Key{}}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+
+		key, err := parseKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("loading key %s: %w", path, err)
+		}
+
+		ks.keys[kid] = key
+	}
+
+	if signingKID == "" {
+		for _, key := range ks.keys {
+			if !key.CanSign() {
+				continue
+			}
+			if ks.signing != nil {
+				return nil, errors.New("multiple private keys found, a signing key id must be set")
+			}
+			ks.signing = key
+		}
+	} else {
+		ks.signing = ks.keys[signingKID]
+	}
+
+	if ks.signing == nil || !ks.signing.CanSign() {
+		return nil, fmt.Errorf("no private signing key found in %s", dir)
+	}
+
+	return ks, nil
+}
+
+// AddHMAC lets the key set verify tokens signed with a shared secret and no kid,
+// so tokens issued before moving to asymmetric keys keep working until they expire
+func (ks *KeySet) AddHMAC(secret string) {
+	ks.keys[""] = &Key{
+		Method: jwt.SigningMethodHS256,
+		Public: []byte(secret),
+	}
+}
+
+func parseKey(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var (
+		parsed any
+		err    error
+	)
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: kid}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, k, k.Public()
+	case *rsa.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	return key, nil
+}
+
+// SigningKey returns the key new tokens are signed with
+func (ks *KeySet) SigningKey() *Key {
+	return ks.signing
+}
+
+// Methods returns the signing algorithms of every key in the set
+func (ks *KeySet) Methods() []string {
+	seen := map[string]bool{}
+	methods := []string{}
+
+	for _, key := range ks.keys {
+		alg := key.Method.Alg()
+		if !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+
+	return methods
+}
+
+// Keyfunc looks up the verification key for a token by its kid header and makes
+// sure the token was signed with the algorithm that key belongs to
+func (ks *KeySet) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, ErrMethodMismatch
+	}
+
+	return key.Public, nil
+}
+
+// publicKeys returns the asymmetric keys in kid order
+func (ks *KeySet) publicKeys() []*Key {
+	keys := []*Key{}
+
+	for _, key := range ks.keys {
+		if _, ok := key.Public.([]byte); ok {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return keys
+}
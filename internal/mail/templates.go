@@ -0,0 +1,156 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Each template file defines a "subject", a "text" and an "html" block and
+// lives in templates/<locale>/<name>.tmpl. The shared HTML header and footer
+// are in templates/layout.tmpl.
+const layoutFile = "templates/layout.tmpl"
+
+type template struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Templates renders localised emails, falling back to the default locale
+// when a template has no translation
+type Templates struct {
+	defaultLocale string
+	locales       map[string]map[string]template
+}
+
+func LoadTemplates(defaultLocale string) (*Templates, error) {
+	entries, err := fs.ReadDir(templateFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Templates{defaultLocale: defaultLocale, locales: map[string]map[string]template{}}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		locale := entry.Name()
+
+		files, err := fs.Glob(templateFS, path.Join("templates", locale, "*.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+
+		t.locales[locale] = map[string]template{}
+		for _, file := range files {
+			name := strings.TrimSuffix(path.Base(file), ".tmpl")
+
+			text, err := texttemplate.New(name).Funcs(funcs(locale)).ParseFS(templateFS, layoutFile, file)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", file, err)
+			}
+
+			html, err := htmltemplate.New(name).Funcs(funcs(locale)).ParseFS(templateFS, layoutFile, file)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", file, err)
+			}
+
+			t.locales[locale][name] = template{text: text, html: html}
+		}
+	}
+
+	if _, ok := t.locales[defaultLocale]; !ok {
+		return nil, fmt.Errorf("no templates for default locale %q", defaultLocale)
+	}
+
+	return t, nil
+}
+
+// Supports reports whether there are templates for the locale
+func (t *Templates) Supports(locale string) bool {
+	_, ok := t.locales[locale]
+	return ok
+}
+
+// Render builds the named email in the recipient's locale. The caller sets To.
+func (t *Templates) Render(name string, locale string, data any) (Message, error) {
+	tmpl, ok := t.locales[locale][name]
+	if !ok {
+		tmpl, ok = t.locales[t.defaultLocale][name]
+		if !ok {
+			return Message{}, fmt.Errorf("unknown email template %q", name)
+		}
+	}
+
+	var subject, text, html bytes.Buffer
+
+	err := tmpl.text.ExecuteTemplate(&subject, "subject", data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	err = tmpl.text.ExecuteTemplate(&text, "text", data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	err = tmpl.html.ExecuteTemplate(&html, "html", data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+func funcs(locale string) map[string]any {
+	return map[string]any{
+		"lang": func() string { return locale },
+		"dir": func() string {
+			if locale == "ar" {
+				return "rtl"
+			}
+			return "ltr"
+		},
+		"duration": func(d time.Duration) string { return formatDuration(locale, d) },
+	}
+}
+
+// formatDuration writes a token lifetime the way a person would, in whole
+// minutes or hours
+func formatDuration(locale string, d time.Duration) string {
+	n, minutes := int(d.Minutes()), true
+	if d >= time.Hour && d%time.Hour == 0 {
+		n, minutes = int(d.Hours()), false
+	}
+
+	switch locale {
+	case "ar":
+		if minutes {
+			return fmt.Sprintf("%d دقيقة", n)
+		}
+		return fmt.Sprintf("%d ساعة", n)
+	default:
+		unit := "hour"
+		if minutes {
+			unit = "minute"
+		}
+		if n != 1 {
+			unit += "s"
+		}
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+}
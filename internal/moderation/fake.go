@@ -0,0 +1,33 @@
+package moderation
+
+import (
+	"context"
+	"sync"
+)
+
+// Fake is a moderator for tests. It returns the configured verdict or error and
+// records every piece of content it was asked about.
+type Fake struct {
+	Verdict Verdict
+	Err     error
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *Fake) Moderate(ctx context.Context, content string) (Verdict, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, content)
+
+	return f.Verdict, f.Err
+}
+
+// Calls returns the content passed to Moderate so far
+func (f *Fake) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.calls...)
+}
@@ -0,0 +1,67 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// Gemini moderates content with a Google Gemini model. The client is created
+// once and shared between requests.
+type Gemini struct {
+	client  *genai.Client
+	model   string
+	timeout time.Duration
+}
+
+func NewGemini(ctx context.Context, apiKey string, model string, timeout time.Duration) (*Gemini, error) {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Gemini{client: client, model: model, timeout: timeout}, nil
+}
+
+func (g *Gemini) Moderate(ctx context.Context, content string) (Verdict, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	model := g.client.GenerativeModel(g.model)
+
+	resp, err := model.GenerateContent(ctx, genai.Text(prompt(content)))
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return Verdict{}, errors.New("gemini returned no candidates")
+	}
+
+	var text strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if t, ok := part.(genai.Text); ok {
+			text.WriteString(string(t))
+		}
+	}
+
+	return parseVerdict(text.String())
+}
+
+func (g *Gemini) Close() error {
+	return g.client.Close()
+}
+
+// Ping checks the model can be reached with our API key
+func (g *Gemini) Ping(ctx context.Context) error {
+	_, err := g.client.GenerativeModel(g.model).Info(ctx)
+	return err
+}
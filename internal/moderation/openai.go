@@ -0,0 +1,118 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAI moderates content with any server speaking the OpenAI chat completions
+// API, including local ones such as Ollama
+type OpenAI struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+func NewOpenAI(baseURL string, apiKey string, model string, timeout time.Duration) *OpenAI {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	return &OpenAI{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		model:   model,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model       string        `json:"model"`
+	Messages    []chatMessage `json:"messages"`
+	Temperature float64       `json:"temperature"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+func (o *OpenAI) Moderate(ctx context.Context, content string) (Verdict, error) {
+	body, err := json.Marshal(chatRequest{
+		Model:       o.model,
+		Messages:    []chatMessage{{Role: "user", Content: prompt(content)}},
+		Temperature: 0,
+	})
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return Verdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Verdict{}, fmt.Errorf("moderation request failed with status %d: %s", resp.StatusCode, msg)
+	}
+
+	var completion chatResponse
+	err = json.NewDecoder(resp.Body).Decode(&completion)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	if len(completion.Choices) == 0 {
+		return Verdict{}, errors.New("moderation response had no choices")
+	}
+
+	return parseVerdict(completion.Choices[0].Message.Content)
+}
+
+// Ping checks the server is up by listing its models
+func (o *OpenAI) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.baseURL+"/models", nil)
+	if err != nil {
+		return err
+	}
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("moderation server returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package moderation
+
+import (
+	"context"
+	"strings"
+	"unicode"
+)
+
+var questionWords = []string{
+	"what", "why", "how", "when", "where", "who", "which", "is", "are", "can", "could",
+	"should", "would", "does", "do", "did", "may", "might", "will", "am",
+}
+
+var advicePhrases = []string{
+	"advice", "advise", "help", "confused", "unsure", "not sure", "wondering", "guidance", "explain",
+}
+
+var recommendationPhrases = []string{
+	"recommend a", "recommend me", "where can i buy", "where to buy", "best brand", "cheapest", "discount code",
+}
+
+// Rules is a deterministic moderator that needs no network access. It is far
+// less capable than an LLM and is meant for local development and as a
+// fallback, flagging banned words, product recommendation requests and
+// content that is not a question.
+type Rules struct {
+	banned []string
+}
+
+func NewRules(bannedWords []string) *Rules {
+	banned := []string{}
+	for _, word := range bannedWords {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word != "" {
+			banned = append(banned, word)
+		}
+	}
+
+	return &Rules{banned: banned}
+}
+
+func (m *Rules) Moderate(ctx context.Context, content string) (Verdict, error) {
+	text := strings.ToLower(strings.TrimSpace(content))
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	})
+
+	if len(words) == 0 {
+		return Verdict{Flagged: true, Reason: "content is empty"}, nil
+	}
+
+	for _, word := range words {
+		for _, banned := range m.banned {
+			if word == banned {
+				return Verdict{Flagged: true, Reason: "content contains offensive language"}, nil
+			}
+		}
+	}
+
+	for _, phrase := range recommendationPhrases {
+		if strings.Contains(text, phrase) {
+			return Verdict{Flagged: true, Reason: "content asks for a product or service recommendation"}, nil
+		}
+	}
+
+	if !isQuestion(text, words) {
+		return Verdict{Flagged: true, Reason: "content is not a question or a request for advice"}, nil
+	}
+
+	return Verdict{}, nil
+}
+
+func isQuestion(text string, words []string) bool {
+	// Latin and Arabic question marks
+	if strings.ContainsAny(text, "?؟") {
+		return true
+	}
+
+	for _, word := range questionWords {
+		if words[0] == word {
+			return true
+		}
+	}
+
+	for _, phrase := range advicePhrases {
+		if strings.Contains(text, phrase) {
+			return true
+		}
+	}
+
+	return false
+}
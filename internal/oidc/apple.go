@@ -0,0 +1,55 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Apple only accepts client secrets that are valid for up to six months, ours
+// are made for each exchange so a few minutes is plenty
+const appleSecretTTL = time.Minute * 5
+
+// AppleClientSecret returns a ClientSecretFunc that signs the ES256 JWT Apple
+// takes as a client secret. The team and key ids come from the Apple developer
+// account and keyPEM is the downloaded .p8 private key.
+func AppleClientSecret(teamID string, keyID string, clientID string, keyPEM []byte) (func() (string, error), error) {
+	if teamID == "" || keyID == "" {
+		return nil, errors.New("oidc: apple client secret needs a team id and a key id")
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("oidc: apple private key is not PEM encoded")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("oidc: apple private key is not an EC key")
+	}
+
+	return func() (string, error) {
+		now := time.Now()
+
+		// Apple wants aud as a plain string, not the array jwt writes by default
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+			"iss": teamID,
+			"sub": clientID,
+			"aud": "https://appleid.apple.com",
+			"iat": now.Unix(),
+			"exp": now.Add(appleSecretTTL).Unix(),
+		})
+		token.Header["kid"] = keyID
+
+		return token.SignedString(key)
+	}, nil
+}
@@ -0,0 +1,102 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IDToken holds the identity claims we use from a verified ID token
+type IDToken struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+	Name          string
+}
+
+type idTokenClaims struct {
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified flexBool `json:"email_verified"`
+	GivenName     string   `json:"given_name"`
+	FamilyName    string   `json:"family_name"`
+	Name          string   `json:"name"`
+	TenantID      string   `json:"tid"`
+	jwt.RegisteredClaims
+}
+
+// flexBool accepts true and "true", some providers send booleans as strings
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	var v any
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case bool:
+		*b = flexBool(v)
+	case string:
+		parsed, _ := strconv.ParseBool(v)
+		*b = flexBool(parsed)
+	}
+
+	return nil
+}
+
+// Verify checks the ID token's signature against the provider's keys, its
+// issuer, audience and expiry, and that it carries the nonce we sent
+func (p *Provider) Verify(ctx context.Context, raw string, nonce string) (*IDToken, error) {
+	d, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "EdDSA"}),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+
+	// A multi-tenant issuer is checked below against the token's own tenant
+	multiTenant := strings.Contains(d.Issuer, tenantPlaceholder)
+	if !multiTenant {
+		options = append(options, jwt.WithIssuer(d.Issuer))
+	}
+
+	var claims idTokenClaims
+	_, err = jwt.ParseWithClaims(raw, &claims, p.keys.keyfunc(ctx), options...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	if multiTenant && (claims.TenantID == "" || strings.Contains(claims.TenantID, "/") || claims.Issuer != strings.Replace(d.Issuer, tenantPlaceholder, claims.TenantID, 1)) {
+		return nil, fmt.Errorf("%w: issuer %q doesn't match tenant %q", ErrInvalidToken, claims.Issuer, claims.TenantID)
+	}
+
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	return &IDToken{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		GivenName:     claims.GivenName,
+		FamilyName:    claims.FamilyName,
+		Name:          claims.Name,
+	}, nil
+}
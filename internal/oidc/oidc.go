@@ -0,0 +1,263 @@
+// Package oidc is a small OpenID Connect relying party: discovery, the
+// authorization code flow with PKCE, and ID token verification.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrExchange     = errors.New("oidc: code exchange failed")
+	ErrInvalidToken = errors.New("oidc: invalid id token")
+)
+
+// ResponseModeFormPost has the provider post the callback as a form instead of
+// redirecting with a query string. Apple requires it when asked for the name
+// or email scope.
+const ResponseModeFormPost = "form_post"
+
+// tenantPlaceholder stands in for the tenant in the issuer of multi-tenant
+// providers such as Microsoft's common endpoint
+const tenantPlaceholder = "{tenantid}"
+
+// Config describes one identity provider
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// ResponseMode is empty for query responses or ResponseModeFormPost
+	ResponseMode string
+
+	// ClientSecretFunc, when set, makes the client secret for each code
+	// exchange. Apple wants a freshly signed JWT rather than a static secret.
+	ClientSecretFunc func() (string, error)
+}
+
+// discovery is the part of the provider metadata we use
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider talks to one identity provider. Its metadata is fetched on first
+// use, so a provider being down doesn't stop the API from starting.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      *keyCache
+}
+
+func NewProvider(cfg Config) *Provider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &Provider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+// FormPost reports whether the provider posts its callback
+func (p *Provider) FormPost() bool {
+	return p.cfg.ResponseMode == ResponseModeFormPost
+}
+
+func (p *Provider) metadata(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+
+	var d discovery
+	err := p.getJSON(ctx, wellKnown, &d)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: discovering %s: %w", p.cfg.Name, err)
+	}
+
+	// The issuer must be the one we were configured with, otherwise tokens
+	// from one provider could be passed off as another's. A multi-tenant
+	// endpoint reports a template that becomes our issuer with the tenant
+	// segment filled in, each token is then checked against its own tenant.
+	if d.Issuer != p.cfg.Issuer && !matchesTenantIssuer(d.Issuer, p.cfg.Issuer) {
+		return nil, fmt.Errorf("oidc: %s issuer is %q, expected %q", p.cfg.Name, d.Issuer, p.cfg.Issuer)
+	}
+
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: %s metadata is incomplete", p.cfg.Name)
+	}
+
+	p.discovery = &d
+	p.keys = newKeyCache(p, d.JWKSURI)
+
+	return p.discovery, nil
+}
+
+// matchesTenantIssuer reports whether template, with the tenant placeholder
+// replaced by the matching path segment of issuer, is issuer
+func matchesTenantIssuer(template string, issuer string) bool {
+	before, after, ok := strings.Cut(template, tenantPlaceholder)
+	if !ok || !strings.HasPrefix(issuer, before) || !strings.HasSuffix(issuer, after) || len(issuer) <= len(before)+len(after) {
+		return false
+	}
+
+	tenant := issuer[len(before) : len(issuer)-len(after)]
+	return !strings.Contains(tenant, "/")
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// AuthRequest is what has to be remembered between sending the user to the
+// provider and handling the callback
+type AuthRequest struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+// NewAuthRequest generates a random state, nonce and PKCE verifier
+func NewAuthRequest() (AuthRequest, error) {
+	var values [3]string
+	for i := range values {
+		b := make([]byte, 32)
+		_, err := rand.Read(b)
+		if err != nil {
+			return AuthRequest{}, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	return AuthRequest{State: values[0], Nonce: values[1], CodeVerifier: values[2]}, nil
+}
+
+// AuthCodeURL is where to send the user to sign in
+func (p *Provider) AuthCodeURL(ctx context.Context, req AuthRequest) (string, error) {
+	d, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(req.CodeVerifier))
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	query.Set("state", req.State)
+	query.Set("nonce", req.Nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	if p.cfg.ResponseMode != "" {
+		query.Set("response_mode", p.cfg.ResponseMode)
+	}
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return d.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// Exchange trades the authorization code for tokens and returns the verified ID token
+func (p *Provider) Exchange(ctx context.Context, code string, req AuthRequest) (*IDToken, error) {
+	d, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := p.cfg.ClientSecret
+	if p.cfg.ClientSecretFunc != nil {
+		secret, err = p.cfg.ClientSecretFunc()
+		if err != nil {
+			return nil, fmt.Errorf("oidc: making %s client secret: %w", p.cfg.Name, err)
+		}
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", req.CodeVerifier)
+	if secret != "" {
+		form.Set("client_secret", secret)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrExchange, resp.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in response", ErrExchange)
+	}
+
+	return p.Verify(ctx, tokens.IDToken, req.Nonce)
+}
@@ -0,0 +1,488 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID = "shaheed-test"
+	testCode     = "good-code"
+	testKeyID    = "key-1"
+)
+
+// mockProvider is a minimal OpenID provider. It remembers the PKCE challenge
+// from the last authorization URL and only redeems testCode with the
+// matching verifier.
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	// issuer reported by discovery, defaults to the server URL
+	issuer string
+	// challenge from the authorization request
+	challenge string
+	// client secret sent with the last code exchange
+	secret string
+	// edit changes the ID token claims and kid before signing
+	edit func(claims jwt.MapClaims, header map[string]any)
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("GET /common/v2.0/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("GET /jwks", m.jwks)
+	mux.HandleFunc("POST /token", m.token)
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	return m
+}
+
+func (m *mockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := m.issuer
+	if issuer == "" {
+		issuer = m.server.URL
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 issuer,
+		"authorization_endpoint": m.server.URL + "/authorize",
+		"token_endpoint":         m.server.URL + "/token",
+		"jwks_uri":               m.server.URL + "/jwks",
+	})
+}
+
+func (m *mockProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := m.key.PublicKey
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKeyID,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (m *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	m.secret = r.PostFormValue("client_secret")
+
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if r.PostFormValue("code") != testCode || base64.RawURLEncoding.EncodeToString(verifier[:]) != m.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"id_token": m.idToken()})
+}
+
+// idToken signs the token the provider hands out. The nonce is added by login
+// through edit, as a real provider takes it from the authorize step.
+func (m *mockProvider) idToken() string {
+	now := time.Now()
+
+	claims := jwt.MapClaims{
+		"iss":            m.server.URL,
+		"sub":            "mock-user-1",
+		"aud":            testClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+		"email":          "amina@example.com",
+		"email_verified": "true",
+		"given_name":     "Amina",
+		"family_name":    "Rahman",
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKeyID
+
+	if m.edit != nil {
+		m.edit(claims, token.Header)
+	}
+
+	raw, err := token.SignedString(m.key)
+	if err != nil {
+		panic(err)
+	}
+
+	return raw
+}
+
+// login runs the flow up to the callback and exchanges the code
+func (m *mockProvider) login(t *testing.T, p *Provider, code string, verifier string) (*IDToken, error) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := p.AuthCodeURL(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+
+	if query.Get("code_challenge_method") != "S256" || query.Get("state") != req.State || query.Get("nonce") != req.Nonce {
+		t.Fatalf("authorization url is missing PKCE, state or nonce: %s", authURL)
+	}
+	m.challenge = query.Get("code_challenge")
+
+	// Unless a test overrides it, the token carries the nonce we sent
+	edit := m.edit
+	m.edit = func(claims jwt.MapClaims, header map[string]any) {
+		claims["nonce"] = req.Nonce
+		if edit != nil {
+			edit(claims, header)
+		}
+	}
+	defer func() { m.edit = edit }()
+
+	if verifier == "" {
+		verifier = req.CodeVerifier
+	}
+
+	return p.Exchange(ctx, code, AuthRequest{Nonce: req.Nonce, CodeVerifier: verifier})
+}
+
+func (m *mockProvider) provider() *Provider {
+	return NewProvider(Config{
+		Name:        "mock",
+		Issuer:      m.server.URL,
+		ClientID:    testClientID,
+		RedirectURL: "http://localhost:8080/v1/auth/oidc/mock/callback",
+	})
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockProvider(t)
+
+	identity, err := m.login(t, m.provider(), testCode, "")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	want := IDToken{
+		Subject:       "mock-user-1",
+		Email:         "amina@example.com",
+		EmailVerified: true,
+		GivenName:     "Amina",
+		FamilyName:    "Rahman",
+	}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		edit     func(claims jwt.MapClaims, header map[string]any)
+		code     string
+		verifier string
+		wantErr  error
+	}{
+		{
+			name:    "bad nonce",
+			edit:    func(claims jwt.MapClaims, _ map[string]any) { claims["nonce"] = "someone-elses-nonce" },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "wrong audience",
+			edit:    func(claims jwt.MapClaims, _ map[string]any) { claims["aud"] = "another-client" },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "wrong issuer",
+			edit:    func(claims jwt.MapClaims, _ map[string]any) { claims["iss"] = "https://evil.example.com" },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "expired",
+			edit:    func(claims jwt.MapClaims, _ map[string]any) { claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "unknown kid",
+			edit:    func(_ jwt.MapClaims, header map[string]any) { header["kid"] = "key-2" },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "missing subject",
+			edit:    func(claims jwt.MapClaims, _ map[string]any) { delete(claims, "sub") },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:     "wrong PKCE verifier",
+			verifier: "not-the-verifier",
+			wantErr:  ErrExchange,
+		},
+		{
+			name:    "unknown code",
+			code:    "stolen-code",
+			wantErr: ErrExchange,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			m.edit = tt.edit
+
+			code := tt.code
+			if code == "" {
+				code = testCode
+			}
+
+			_, err := m.login(t, m.provider(), code, tt.verifier)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Exchange error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	m := newMockProvider(t)
+	m.issuer = "https://other.example.com"
+
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.provider().AuthCodeURL(context.Background(), req)
+	if err == nil {
+		t.Fatal("AuthCodeURL accepted metadata for another issuer")
+	}
+}
+
+func TestVerifyWithoutKeyID(t *testing.T) {
+	m := newMockProvider(t)
+
+	// A provider with a single key may leave out the kid
+	m.edit = func(_ jwt.MapClaims, header map[string]any) { delete(header, "kid") }
+
+	_, err := m.login(t, m.provider(), testCode, "")
+	if err != nil {
+		t.Errorf("Exchange: %v", err)
+	}
+}
+
+func TestFormPost(t *testing.T) {
+	m := newMockProvider(t)
+
+	p := NewProvider(Config{
+		Name:         "mock",
+		Issuer:       m.server.URL,
+		ClientID:     testClientID,
+		ResponseMode: ResponseModeFormPost,
+	})
+
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := p.AuthCodeURL(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := u.Query().Get("response_mode"); got != ResponseModeFormPost || !p.FormPost() {
+		t.Errorf("response_mode = %q, want %q", got, ResponseModeFormPost)
+	}
+}
+
+func TestClientSecretFunc(t *testing.T) {
+	m := newMockProvider(t)
+
+	calls := 0
+	p := NewProvider(Config{
+		Name:         "mock",
+		Issuer:       m.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: "static",
+		ClientSecretFunc: func() (string, error) {
+			calls++
+			return "signed-" + strconv.Itoa(calls), nil
+		},
+	})
+
+	for want := 1; want <= 2; want++ {
+		_, err := m.login(t, p, testCode, "")
+		if err != nil {
+			t.Fatalf("Exchange: %v", err)
+		}
+
+		if m.secret != "signed-"+strconv.Itoa(want) {
+			t.Errorf("client_secret = %q, want a fresh one from ClientSecretFunc", m.secret)
+		}
+	}
+}
+
+func TestAppleClientSecret(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := AppleClientSecret("TEAM123456", "KEY1234567", "com.example.shaheed", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := secret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (any, error) { return &key.PublicKey, nil },
+		jwt.WithValidMethods([]string{"ES256"}),
+		jwt.WithIssuer("TEAM123456"),
+		jwt.WithSubject("com.example.shaheed"),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		t.Fatalf("parsing client secret: %v", err)
+	}
+
+	if token.Header["kid"] != "KEY1234567" {
+		t.Errorf("kid = %v, want KEY1234567", token.Header["kid"])
+	}
+	if claims["aud"] != "https://appleid.apple.com" {
+		t.Errorf("aud = %v, want the plain string https://appleid.apple.com", claims["aud"])
+	}
+
+	_, err = AppleClientSecret("TEAM123456", "KEY1234567", "com.example.shaheed", []byte("not a key"))
+	if err == nil {
+		t.Error("AppleClientSecret accepted a key that isn't PEM")
+	}
+}
+
+func TestMultiTenantIssuer(t *testing.T) {
+	tenantIssuer := func(tenant string) func(claims jwt.MapClaims, _ map[string]any) {
+		return func(claims jwt.MapClaims, _ map[string]any) {
+			claims["tid"] = tenant
+			claims["iss"] = claims["iss"].(string) + "/" + tenant + "/v2.0"
+		}
+	}
+
+	tests := []struct {
+		name    string
+		edit    func(claims jwt.MapClaims, header map[string]any)
+		wantErr error
+	}{
+		{
+			name: "token from a tenant",
+			edit: tenantIssuer("9188040d-6c67-4c5b-b112-36a304b66dad"),
+		},
+		{
+			name: "issuer of another tenant",
+			edit: func(claims jwt.MapClaims, header map[string]any) {
+				tenantIssuer("tenant-a")(claims, header)
+				claims["tid"] = "tenant-b"
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "missing tenant",
+			edit:    func(claims jwt.MapClaims, _ map[string]any) { claims["iss"] = claims["iss"].(string) + "//v2.0" },
+			wantErr: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			m.issuer = m.server.URL + "/{tenantid}/v2.0"
+			m.edit = tt.edit
+
+			p := NewProvider(Config{
+				Name:     "microsoft",
+				Issuer:   m.server.URL + "/common/v2.0",
+				ClientID: testClientID,
+			})
+
+			_, err := m.login(t, p, testCode, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Exchange error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatchesTenantIssuer(t *testing.T) {
+	template := "https://login.microsoftonline.com/{tenantid}/v2.0"
+
+	tests := []struct {
+		issuer string
+		want   bool
+	}{
+		{"https://login.microsoftonline.com/common/v2.0", true},
+		{"https://login.microsoftonline.com/organizations/v2.0", true},
+		{"https://login.microsoftonline.com/v2.0", false},
+		{"https://login.microsoftonline.com/a/b/v2.0", false},
+		{"https://evil.example.com/common/v2.0", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchesTenantIssuer(template, tt.issuer); got != tt.want {
+			t.Errorf("matchesTenantIssuer(%q) = %v, want %v", tt.issuer, got, tt.want)
+		}
+	}
+
+	if matchesTenantIssuer("https://accounts.google.com", "https://accounts.google.com") {
+		t.Error("matchesTenantIssuer matched an issuer without a tenant placeholder")
+	}
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often keys whose requests have all expired are dropped
+const sweepInterval = time.Minute
+
+type window struct {
+	length   time.Duration
+	requests []time.Time
+}
+
+// Memory is a sliding window limiter local to this instance, used when Redis
+// cannot be reached
+type Memory struct {
+	mu        sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{windows: map[string]*window{}, lastSweep: time.Now()}
+}
+
+func (l *Memory) Allow(ctx context.Context, key string, limit int, length time.Duration) (Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	w, ok := l.windows[key]
+	if !ok {
+		w = &window{length: length}
+		l.windows[key] = w
+	}
+	w.length = length
+	w.trim(now)
+
+	allowed := len(w.requests) < limit
+	if allowed {
+		w.requests = append(w.requests, now)
+	}
+
+	reset := length
+	if len(w.requests) > 0 {
+		reset = w.requests[0].Add(length).Sub(now)
+	}
+
+	return Result{
+		Allowed:    allowed,
+		Limit:      limit,
+		Remaining:  remaining(limit, len(w.requests)),
+		ResetAfter: reset,
+	}, nil
+}
+
+// trim drops the requests that have left the window
+func (w *window) trim(now time.Time) {
+	cutoff := now.Add(-w.length)
+
+	i := 0
+	for i < len(w.requests) && !w.requests[i].After(cutoff) {
+		i++
+	}
+
+	w.requests = w.requests[i:]
+}
+
+func (l *Memory) sweep(now time.Time) {
+	for key, w := range l.windows {
+		w.trim(now)
+		if len(w.requests) == 0 {
+			delete(l.windows, key)
+		}
+	}
+
+	l.lastSweep = now
+}
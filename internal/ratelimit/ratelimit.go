@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Result describes the state of a key after a request was counted against it
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+
+	// ResetAfter is how long until the oldest request leaves the window
+	ResetAfter time.Duration
+}
+
+// Limiter counts requests per key over a sliding window
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (Result, error)
+}
+
+// retryPrimaryAfter is how long the fallback sticks with the secondary limiter
+// before trying the primary again, so requests do not all wait on a dead Redis
+const retryPrimaryAfter = 10 * time.Second
+
+// Fallback uses the primary limiter and switches to the secondary one whenever
+// the primary fails, so an unavailable Redis does not take the API down with it
+type Fallback struct {
+	primary   Limiter
+	secondary Limiter
+	retryAt   atomic.Int64
+}
+
+func NewFallback(primary Limiter, secondary Limiter) *Fallback {
+	return &Fallback{primary: primary, secondary: secondary}
+}
+
+func (f *Fallback) Allow(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	retryAt := f.retryAt.Load()
+	if retryAt != 0 && time.Now().UnixNano() < retryAt {
+		return f.secondary.Allow(ctx, key, limit, window)
+	}
+
+	result, err := f.primary.Allow(ctx, key, limit, window)
+	if err == nil {
+		if retryAt != 0 && f.retryAt.CompareAndSwap(retryAt, 0) {
+			log.Print("rate limiter recovered, using the primary limiter again")
+		}
+		return result, nil
+	}
+
+	// Only log when switching over rather than on every request
+	if f.retryAt.Swap(time.Now().Add(retryPrimaryAfter).UnixNano()) == 0 {
+		log.Printf("rate limiter failed, falling back to in-memory limits: %v", err)
+	}
+
+	return f.secondary.Allow(ctx, key, limit, window)
+}
+
+func remaining(limit int, count int) int {
+	if count >= limit {
+		return 0
+	}
+	return limit - count
+}
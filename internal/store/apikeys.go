@@ -0,0 +1,405 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// APIKeyScope limits what a request made with an API key may do
+type APIKeyScope string
+
+const (
+	APIKeyScopeRead     APIKeyScope = "read"
+	APIKeyScopePost     APIKeyScope = "post"
+	APIKeyScopeModerate APIKeyScope = "moderate"
+	APIKeyScopeAdmin    APIKeyScope = "admin"
+)
+
+// apiKeyScopeRanks orders the scopes, each one allows everything below it
+var apiKeyScopeRanks = map[APIKeyScope]int{
+	APIKeyScopeRead:     1,
+	APIKeyScopePost:     2,
+	APIKeyScopeModerate: 3,
+	APIKeyScopeAdmin:    4,
+}
+
+// Valid reports whether the scope is one we know about
+func (s APIKeyScope) Valid() bool {
+	_, ok := apiKeyScopeRanks[s]
+	return ok
+}
+
+// Allows reports whether the scope grants at least the access of other
+func (s APIKeyScope) Allows(other APIKeyScope) bool {
+	rank, ok := apiKeyScopeRanks[s]
+	if !ok {
+		return false
+	}
+
+	return rank >= apiKeyScopeRanks[other]
+}
+
+// Role is the most privileged role a request made with the key acts as. It is
+// also the least privileged role that may hold a key with this scope.
+func (s APIKeyScope) Role() Role {
+	switch s {
+	case APIKeyScopeAdmin:
+		return RoleAdmin
+	case APIKeyScopeModerate:
+		return RoleModerator
+	default:
+		return RoleMember
+	}
+}
+
+// API keys look like shk_<prefix>_<secret>. The prefix identifies the key and
+// is safe to show and log.
+const apiKeyPrefix = "shk_"
+
+// How often a key's last used time is written while it is being used
+const apiKeyTouchInterval = time.Minute
+
+var ErrInvalidAPIKey = errors.New("invalid or expired api key")
+
+var apiKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type APIKey struct {
+	ID         int64       `json:"id"`
+	UserID     int         `json:"user_id"`
+	UserEmail  string      `json:"user_email,omitempty"`
+	Name       string      `json:"name"`
+	Prefix     string      `json:"prefix"`
+	Scope      APIKeyScope `json:"scope"`
+	CreatedBy  *int        `json:"created_by"`
+	CreatedAt  time.Time   `json:"created_at"`
+	ExpiresAt  *time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time  `json:"last_used_at"`
+	LastUsedIP string      `json:"last_used_ip"`
+	RevokedAt  *time.Time  `json:"revoked_at"`
+}
+
+// Active reports whether the key can still be used
+func (k APIKey) Active() bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || k.ExpiresAt.After(time.Now()))
+}
+
+// NewAPIKey is what is needed to issue a key
+type NewAPIKey struct {
+	UserID    int
+	Name      string
+	Scope     APIKeyScope
+	CreatedBy int
+	ExpiresAt *time.Time
+}
+
+// APIKeyStore manages API keys. Like user tokens only a hash of the secret is
+// stored, the plain key is shown once when it is created.
+type APIKeyStore struct {
+	db  *sql.DB
+	rdb *redis.Client
+}
+
+// IsAPIKey reports whether the credential looks like an API key rather than a JWT
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, apiKeyPrefix)
+}
+
+// APIKeyPrefix returns the identifying part of a key, or the prefix itself
+// when given one. Used to look up keys found in logs.
+func APIKeyPrefix(key string) string {
+	if !IsAPIKey(key) {
+		return ""
+	}
+
+	id, _, _ := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if id == "" {
+		return ""
+	}
+
+	return apiKeyPrefix + id
+}
+
+func randomAPIKeyPart(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(apiKeyEncoding.EncodeToString(b)), nil
+}
+
+const apiKeyColumns = `
+	k.id, k.user_id, u.email, k.name, k.prefix, k.scope, k.created_by, k.created_at,
+	k.expires_at, k.last_used_at, k.last_used_ip, k.revoked_at
+`
+
+// scanAPIKey reads the apiKeyColumns, followed by any extra columns into extra
+func scanAPIKey(row interface{ Scan(...any) error }, extra ...any) (APIKey, error) {
+	var (
+		key       APIKey
+		createdBy sql.NullInt64
+	)
+
+	dest := []any{&key.ID, &key.UserID, &key.UserEmail, &key.Name, &key.Prefix, &key.Scope, &createdBy, &key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt, &key.LastUsedIP, &key.RevokedAt}
+
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	if createdBy.Valid {
+		id := int(createdBy.Int64)
+		key.CreatedBy = &id
+	}
+
+	return key, nil
+}
+
+// Create issues a key and returns it along with its plain text form
+func (s *APIKeyStore) Create(ctx context.Context, request NewAPIKey) (APIKey, string, error) {
+
+	var createdBy sql.NullInt64
+	if request.CreatedBy != 0 {
+		createdBy = sql.NullInt64{Int64: int64(request.CreatedBy), Valid: true}
+	}
+
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scope, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`
+
+	// Prefixes are short enough to collide now and then, just pick another
+	for attempt := 0; ; attempt++ {
+		id, err := randomAPIKeyPart(5)
+		if err != nil {
+			return APIKey{}, "", err
+		}
+
+		secret, err := randomAPIKeyPart(20)
+		if err != nil {
+			return APIKey{}, "", err
+		}
+
+		prefix := apiKeyPrefix + id
+		plain := prefix + "_" + secret
+
+		var keyID int64
+		err = s.db.QueryRowContext(ctx, query, request.UserID, request.Name, prefix, hashUserToken(plain), request.Scope, createdBy, request.ExpiresAt).Scan(&keyID)
+		if err != nil {
+			if isUniqueViolation(err) && attempt < 3 {
+				continue
+			}
+			return APIKey{}, "", err
+		}
+
+		key, err := s.get(ctx, keyID)
+		if err != nil {
+			return APIKey{}, "", err
+		}
+
+		return key, plain, nil
+	}
+}
+
+func (s *APIKeyStore) get(ctx context.Context, id int64) (APIKey, error) {
+
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.id = $1
+	`
+
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, ErrNotFound
+		}
+		return APIKey{}, err
+	}
+
+	return key, nil
+}
+
+// Authenticate returns the key a request was made with, if it is still active
+func (s *APIKeyStore) Authenticate(ctx context.Context, plain string) (APIKey, error) {
+
+	prefix := APIKeyPrefix(plain)
+	if prefix == "" {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+
+	query := `
+		SELECT ` + apiKeyColumns + `, k.key_hash
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.prefix = $1
+	`
+
+	var hash string
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, query, prefix), &hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, ErrInvalidAPIKey
+		}
+		return APIKey{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(hashUserToken(plain))) != 1 || !key.Active() {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+
+	return key, nil
+}
+
+// List returns the user's keys that haven't been revoked, newest first
+func (s *APIKeyStore) List(ctx context.Context, userID int) ([]APIKey, error) {
+
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.user_id = $1 AND k.revoked_at IS NULL
+		ORDER BY k.created_at DESC
+	`
+
+	return s.list(ctx, query, userID)
+}
+
+// ListAll returns every key that hasn't been revoked. Given a prefix it
+// returns that key whether or not it was revoked.
+func (s *APIKeyStore) ListAll(ctx context.Context, prefix string) ([]APIKey, error) {
+
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE ($1 = '' AND k.revoked_at IS NULL) OR k.prefix = $1
+		ORDER BY k.created_at DESC
+	`
+
+	return s.list(ctx, query, prefix)
+}
+
+func (s *APIKeyStore) list(ctx context.Context, query string, arg any) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keys := []APIKey{}
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Rename changes the name of one of the user's keys
+func (s *APIKeyStore) Rename(ctx context.Context, userID int, id int64, name string) error {
+
+	query := `
+		UPDATE api_keys SET name = $3
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+
+	return s.execOne(ctx, query, id, userID, name)
+}
+
+// Revoke revokes one of the user's keys
+func (s *APIKeyStore) Revoke(ctx context.Context, userID int, id int64) error {
+
+	query := `
+		UPDATE api_keys SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+
+	return s.execOne(ctx, query, id, userID)
+}
+
+// RevokeAny revokes a key whoever it belongs to
+func (s *APIKeyStore) RevokeAny(ctx context.Context, id int64) error {
+
+	query := `
+		UPDATE api_keys SET revoked_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL
+	`
+
+	return s.execOne(ctx, query, id)
+}
+
+// RevokeSelfIssued revokes the keys the user issued to themselves. Keys an
+// admin issued to them, and keys they issued to other accounts such as
+// service accounts, are left alone.
+func (s *APIKeyStore) RevokeSelfIssued(ctx context.Context, userID int) error {
+
+	query := `
+		UPDATE api_keys SET revoked_at = NOW()
+		WHERE user_id = $1 AND created_by = $1 AND revoked_at IS NULL
+	`
+
+	_, err := s.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+// execOne runs an update that has to hit exactly one key
+func (s *APIKeyStore) execOne(ctx context.Context, query string, args ...any) error {
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Touch records that the key was just used. Like sessions it writes at most
+// once per apiKeyTouchInterval for each key.
+func (s *APIKeyStore) Touch(ctx context.Context, id int64, ip string) error {
+	first, err := s.rdb.SetNX(ctx, "apikey:touched:"+strconv.FormatInt(id, 10), 1, apiKeyTouchInterval).Result()
+	if err != nil || !first {
+		return err
+	}
+
+	query := `
+		UPDATE api_keys SET last_used_at = NOW(), last_used_ip = $2
+		WHERE id = $1
+	`
+
+	_, err = s.db.ExecContext(ctx, query, id, ip)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -4,45 +4,93 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"log"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthStore struct {
 	db *sql.DB
+	// Server side key the refresh token hashes are computed with
+	pepper []byte
 }
 
 type RegisterRequest struct {
 	FirstName    string `json:"first_name"`
 	LastName     string `json:"last_name"`
 	Email        string `json:"email"`
+	Locale       string `json:"locale"`
 	PasswordHash string `json:"password_hash"`
 }
 
-func (s *AuthStore) Register(ctx context.Context, request RegisterRequest) error {
+func (s *AuthStore) Register(ctx context.Context, request RegisterRequest) (int, error) {
 
 	query := `
-		INSERT INTO users (first_name, last_name, email, password_hash)
+		INSERT INTO users (first_name, last_name, email, locale, password_hash)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	var id int
+	err := s.db.QueryRowContext(ctx, query, request.FirstName, request.LastName, request.Email, request.Locale, request.PasswordHash).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// StoreRefreshToken stores the first refresh token of a new login, starting a
+// new session whose id is shared by every token rotated from this one
+func (s *AuthStore) StoreRefreshToken(ctx context.Context, userID int, sessionID string, token string, expiresAt time.Time, device Device) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO sessions (id, user_id, user_agent, ip, label, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err = tx.ExecContext(ctx, query, sessionID, userID, device.UserAgent, device.IP, device.Label, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at)
 		VALUES ($1, $2, $3, $4)
 	`
 
-	_, err := s.db.ExecContext(ctx, query, request.FirstName, request.LastName, request.Email, request.PasswordHash)
+	_, err = tx.ExecContext(ctx, query, userID, s.hashToken(token), sessionID, expiresAt)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-func (s *AuthStore) StoreRefreshToken(ctx context.Context, userID int, token string, expiresAt time.Time) error {
+// RevokeRefreshToken revokes the whole token family the refresh token belongs to, ending that session
+func (s *AuthStore) RevokeRefreshToken(ctx context.Context, userID int, token string) error {
 	query := `
-		INSERT INTO refresh_tokens (user_id, token, expires_at)
-		VALUES ($1, $2, $3)
+		WITH family AS (
+			SELECT family_id FROM refresh_tokens WHERE token_hash = $1 AND user_id = $2
+		), revoked AS (
+			UPDATE refresh_tokens SET revoked_at = NOW()
+			WHERE revoked_at IS NULL AND family_id = (SELECT family_id FROM family)
+		)
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE revoked_at IS NULL AND id = (SELECT family_id FROM family)
 	`
 
-	_, err := s.db.ExecContext(ctx, query, userID, token, expiresAt)
+	_, err := s.db.ExecContext(ctx, query, s.hashToken(token), userID)
 	if err != nil {
 		return err
 	}
@@ -50,6 +98,44 @@ func (s *AuthStore) StoreRefreshToken(ctx context.Context, userID int, token str
 	return nil
 }
 
+// RevokeUserRefreshTokens revokes every refresh token belonging to the user,
+// ending all of their sessions, and returns the ids of the sessions it ended
+func (s *AuthStore) RevokeUserRefreshTokens(ctx context.Context, userID int) ([]string, error) {
+	query := `
+		WITH revoked AS (
+			UPDATE refresh_tokens SET revoked_at = NOW()
+			WHERE user_id = $1 AND revoked_at IS NULL
+		)
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+		RETURNING id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sessionIDs := []string{}
+
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		sessionIDs = append(sessionIDs, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessionIDs, nil
+}
+
 func (s *AuthStore) HashPassword(password string) (string, error) {
 
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -62,11 +148,16 @@ func (s *AuthStore) HashPassword(password string) (string, error) {
 
 func (s *AuthStore) VerifyPassword(password string, hash string) (bool, error) {
 
+	// Users who signed up through an identity provider have no password
+	if hash == "" {
+		return false, nil
+	}
+
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	if err != nil {
 		switch err {
 		case bcrypt.ErrMismatchedHashAndPassword:
-			return false, errors.New("invalid credentials")
+			return false, nil
 		default:
 			return false, err
 		}
@@ -75,13 +166,30 @@ func (s *AuthStore) VerifyPassword(password string, hash string) (bool, error) {
 	return true, nil
 }
 
-func (s *AuthStore) GenerateJWT(userID int, expiresAt time.Time, secret string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"exp":     expiresAt.Unix(),
-	})
+func (s *AuthStore) GenerateJWT(userID int, sessionID string, tokenType TokenType, expiresAt time.Time, cfg TokenConfig) (string, error) {
+	now := time.Now()
+
+	claims := Claims{
+		Type:      tokenType,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   strconv.Itoa(userID),
+			Issuer:    cfg.Issuer,
+			Audience:  jwt.ClaimStrings{cfg.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	key := cfg.Keys.SigningKey()
 
-	tokenString, err := token.SignedString([]byte(secret))
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+
+	tokenString, err := token.SignedString(key.Private)
 	if err != nil {
 		return "", err
 	}
@@ -89,11 +197,18 @@ func (s *AuthStore) GenerateJWT(userID int, expiresAt time.Time, secret string)
 	return tokenString, nil
 }
 
-func (s *AuthStore) VerifyToken(tokenString string, secret string) (*jwt.Token, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
-		return []byte(secret), nil
-	})
+// VerifyToken parses and validates a token, making sure it was signed with the
+// expected method, is meant for us and is of the expected type
+func (s *AuthStore) VerifyToken(tokenString string, tokenType TokenType, cfg TokenConfig) (*Claims, error) {
+	claims := &Claims{}
 
+	token, err := jwt.ParseWithClaims(tokenString, claims, cfg.Keys.Keyfunc,
+		jwt.WithValidMethods(cfg.Keys.Methods()),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithAudience(cfg.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -102,38 +217,148 @@ func (s *AuthStore) VerifyToken(tokenString string, secret string) (*jwt.Token,
 		return nil, errors.New("invalid token")
 	}
 
-	return token, nil
+	if claims.Type != tokenType {
+		return nil, errors.New("invalid token type")
+	}
+
+	if claims.ID == "" || claims.Subject == "" {
+		return nil, errors.New("invalid token claims")
+	}
+
+	return claims, nil
 }
 
-func (s *AuthStore) RefreshToken(ctx context.Context, userID int, tokenString string, secret string, refreshExp time.Duration, accessExp time.Duration) (string, string, error) {
+// RefreshToken rotates a refresh token. The presented token is marked as used and
+// a child token in the same family is issued. Presenting a token that was already
+// used means it has been stolen or replayed, so the whole family is revoked.
+func (s *AuthStore) RefreshToken(ctx context.Context, userID int, tokenString string, ip string, cfg TokenConfig, refreshExp time.Duration, accessExp time.Duration) (string, string, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
 
-	// Delete the old refresh token
+	// Lock the token so concurrent refreshes cannot both rotate it
 	query := `
-		DELETE FROM refresh_tokens
-		WHERE token = $1 AND user_id = $2
+		SELECT id, family_id, expires_at, used_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1 AND user_id = $2
+		FOR UPDATE
+	`
+
+	var (
+		tokenID   int
+		familyID  string
+		expiresAt time.Time
+		usedAt    sql.NullTime
+		revokedAt sql.NullTime
+	)
+	err = tx.QueryRowContext(ctx, query, s.hashToken(tokenString), userID).Scan(&tokenID, &familyID, &expiresAt, &usedAt, &revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", ErrInvalidRefreshToken
+		}
+		return "", "", err
+	}
+
+	if revokedAt.Valid || time.Now().After(expiresAt) {
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	// Reuse of a rotated token, revoke the whole family
+	if usedAt.Valid {
+		query = `
+			UPDATE refresh_tokens SET revoked_at = NOW()
+			WHERE family_id = $1 AND revoked_at IS NULL
+		`
+		_, err = tx.ExecContext(ctx, query, familyID)
+		if err != nil {
+			return "", "", err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, familyID)
+		if err != nil {
+			return "", "", err
+		}
+
+		err = logSecurityEvent(ctx, tx, userID, "refresh_token_reuse", fmt.Sprintf("family %s revoked after token %d was reused", familyID, tokenID))
+		if err != nil {
+			return "", "", err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return "", "", err
+		}
+
+		return "", "", ErrRefreshTokenReused
+	}
+
+	// Mark the old refresh token as used
+	query = `
+		UPDATE refresh_tokens SET used_at = NOW()
+		WHERE id = $1
 	`
-	_, err := s.db.ExecContext(ctx, query, tokenString, userID)
+	_, err = tx.ExecContext(ctx, query, tokenID)
 	if err != nil {
 		return "", "", err
 	}
 
 	// Generate a new refresh token
-	refreshToken, err := s.GenerateJWT(userID, time.Now().Add(refreshExp), secret)
+	refreshToken, err := s.GenerateJWT(userID, familyID, TokenTypeRefresh, time.Now().Add(refreshExp), cfg)
 	if err != nil {
 		return "", "", err
 	}
 
-	// Store the new refresh token
-	err = s.StoreRefreshToken(ctx, userID, refreshToken, time.Now().Add(refreshExp))
+	// Store the new refresh token as a child of the old one
+	query = `
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, parent_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err = tx.ExecContext(ctx, query, userID, s.hashToken(refreshToken), familyID, tokenID, time.Now().Add(refreshExp))
+	if err != nil {
+		return "", "", err
+	}
+
+	// The session lives on as long as its newest refresh token
+	query = `
+		UPDATE sessions SET last_used_at = NOW(), ip = $2, expires_at = $3
+		WHERE id = $1
+	`
+
+	_, err = tx.ExecContext(ctx, query, familyID, ip, time.Now().Add(refreshExp))
+	if err != nil {
+		return "", "", err
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return "", "", err
 	}
 
 	// Generate a new access token
-	tokenString, err = s.GenerateJWT(userID, time.Now().Add(accessExp), secret)
+	tokenString, err = s.GenerateJWT(userID, familyID, TokenTypeAccess, time.Now().Add(accessExp), cfg)
 	if err != nil {
 		return "", "", err
 	}
 
 	return tokenString, refreshToken, nil
 }
+
+// logSecurityEvent records a security relevant event for later auditing
+func logSecurityEvent(ctx context.Context, tx *sql.Tx, userID int, event string, details string) error {
+	query := `
+		INSERT INTO security_events (user_id, event, details)
+		VALUES ($1, $2, $3)
+	`
+
+	_, err := tx.ExecContext(ctx, query, userID, event, details)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("security event: %s for user %d: %s", event, userID, details)
+
+	return nil
+}
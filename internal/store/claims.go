@@ -0,0 +1,41 @@
+package store
+
+import (
+	"strconv"
+
+	"github.com/RakibulBh/shaheed-backend/internal/keys"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenType tells apart the different kinds of JWT we issue
+type TokenType string
+
+const (
+	TokenTypeAccess  TokenType = "access"
+	TokenTypeRefresh TokenType = "refresh"
+
+	// TokenTypeChallenge proves the password was right while login waits for
+	// a second factor. It grants nothing else.
+	TokenTypeChallenge TokenType = "2fa_challenge"
+)
+
+// Claims are the claims carried by every JWT we issue. The user id lives in the
+// standard sub claim and jti uniquely identifies the token. sid is the session
+// the token was issued in, if any.
+type Claims struct {
+	Type      TokenType `json:"typ"`
+	SessionID string    `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// UserID parses the user id out of the subject claim
+func (c *Claims) UserID() (int, error) {
+	return strconv.Atoi(c.Subject)
+}
+
+// TokenConfig holds everything needed to sign and verify tokens
+type TokenConfig struct {
+	Keys     *keys.KeySet
+	Issuer   string
+	Audience string
+}
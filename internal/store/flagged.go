@@ -0,0 +1,188 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// FlagStatus is where a flagged question is in the moderation queue
+type FlagStatus string
+
+const (
+	FlagStatusPending  FlagStatus = "pending"
+	FlagStatusApproved FlagStatus = "approved"
+	FlagStatusRejected FlagStatus = "rejected"
+)
+
+// Valid reports whether the status is one we know about
+func (s FlagStatus) Valid() bool {
+	switch s {
+	case FlagStatusPending, FlagStatusApproved, FlagStatusRejected:
+		return true
+	}
+	return false
+}
+
+type FlaggedStore struct {
+	db        *sql.DB
+	questions *QuestionStore
+}
+
+type FlaggedQuestion struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Content    string     `json:"content"`
+	ParentID   int        `json:"parent_id"`
+	Location   string     `json:"location"`
+	Reason     string     `json:"reason"`
+	Status     FlagStatus `json:"status"`
+	ReviewedBy *int       `json:"reviewed_by"`
+	ReviewedAt *time.Time `json:"reviewed_at"`
+	ReviewNote string     `json:"review_note"`
+	QuestionID *int       `json:"question_id"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (s *FlaggedStore) List(ctx context.Context, status FlagStatus) ([]FlaggedQuestion, error) {
+
+	query := `
+		SELECT id, user_id, content, COALESCE(parent_id, 0), location, reason, status, reviewed_by, reviewed_at, review_note, question_id, created_at
+		FROM flagged_questions
+		WHERE status = $1
+		ORDER BY created_at ASC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, status)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	flagged := []FlaggedQuestion{}
+
+	for rows.Next() {
+		var f FlaggedQuestion
+		err := rows.Scan(&f.ID, &f.UserID, &f.Content, &f.ParentID, &f.Location, &f.Reason, &f.Status, &f.ReviewedBy, &f.ReviewedAt, &f.ReviewNote, &f.QuestionID, &f.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		flagged = append(flagged, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return flagged, nil
+}
+
+func (s *FlaggedStore) Get(ctx context.Context, id int) (*FlaggedQuestion, error) {
+
+	query := `
+		SELECT id, user_id, content, COALESCE(parent_id, 0), location, reason, status, reviewed_by, reviewed_at, review_note, question_id, created_at
+		FROM flagged_questions
+		WHERE id = $1
+	`
+
+	f := &FlaggedQuestion{}
+
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&f.ID, &f.UserID, &f.Content, &f.ParentID, &f.Location, &f.Reason, &f.Status, &f.ReviewedBy, &f.ReviewedAt, &f.ReviewNote, &f.QuestionID, &f.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Approve publishes a pending flagged question with the given content and
+// location through QuestionStore.create and records the moderator's decision,
+// both in one transaction.
+// ErrConflict is returned if someone else reviewed it first.
+func (s *FlaggedStore) Approve(ctx context.Context, id int, moderatorID int, content string, location string, note string) (*Question, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT user_id, COALESCE(parent_id, 0), status
+		FROM flagged_questions
+		WHERE id = $1
+		FOR UPDATE
+	`
+
+	var (
+		userID, parentID int
+		status           FlagStatus
+	)
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&userID, &parentID, &status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if status != FlagStatusPending {
+		return nil, ErrConflict
+	}
+
+	question, err := s.questions.create(ctx, tx, userID, content, parentID, location)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		UPDATE flagged_questions
+		SET status = 'approved', reviewed_by = $1, reviewed_at = NOW(), review_note = $2, question_id = $3
+		WHERE id = $4
+	`
+
+	_, err = tx.ExecContext(ctx, query, moderatorID, note, question.ID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return question, nil
+}
+
+// Reject records a moderator's decision to turn down a pending flagged
+// question. ErrConflict is returned if someone else reviewed it first.
+func (s *FlaggedStore) Reject(ctx context.Context, id int, moderatorID int, note string) error {
+
+	query := `
+		UPDATE flagged_questions
+		SET status = 'rejected', reviewed_by = $1, reviewed_at = NOW(), review_note = $2
+		WHERE id = $3 AND status = 'pending'
+	`
+
+	result, err := s.db.ExecContext(ctx, query, moderatorID, note, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrConflict
+	}
+
+	return nil
+}
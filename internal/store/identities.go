@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// ExternalUser is a new user signing in through an identity provider
+type ExternalUser struct {
+	FirstName     string
+	LastName      string
+	Email         string
+	EmailVerified bool
+	Locale        string
+	Provider      string
+	Subject       string
+}
+
+type IdentityStore struct {
+	db *sql.DB
+}
+
+// FindUser returns the user linked to the provider account and records the login
+func (s *IdentityStore) FindUser(ctx context.Context, provider string, subject string) (int, error) {
+
+	query := `
+		UPDATE user_identities SET last_login_at = NOW()
+		WHERE provider = $1 AND subject = $2
+		RETURNING user_id
+	`
+
+	var userID int
+	err := s.db.QueryRowContext(ctx, query, provider, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRows
+		}
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// Link attaches a provider account to an existing user
+func (s *IdentityStore) Link(ctx context.Context, userID int, provider string, subject string, email string) error {
+
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, NULLIF($4, ''))
+	`
+
+	_, err := s.db.ExecContext(ctx, query, userID, provider, subject, email)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrConflict
+		}
+		return err
+	}
+
+	return nil
+}
+
+// CreateUser creates a passwordless user linked to the provider account
+func (s *IdentityStore) CreateUser(ctx context.Context, user ExternalUser) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO users (first_name, last_name, email, locale, email_verified_at)
+		VALUES ($1, $2, $3, $4, CASE WHEN $5 THEN NOW() END)
+		RETURNING id
+	`
+
+	var userID int
+	err = tx.QueryRowContext(ctx, query, user.FirstName, user.LastName, user.Email, user.Locale, user.EmailVerified).Scan(&userID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrConflict
+		}
+		return 0, err
+	}
+
+	query = `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err = tx.ExecContext(ctx, query, userID, user.Provider, user.Subject, user.Email)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrConflict
+		}
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// OIDCLoginState is kept between redirecting to a provider and its callback
+type OIDCLoginState struct {
+	Provider     string `json:"provider"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+// OIDCStateStore keeps login state in Redis, keyed by the state parameter
+type OIDCStateStore struct {
+	rdb *redis.Client
+}
+
+func oidcStateKey(state string) string {
+	return fmt.Sprintf("oidc:state:%s", state)
+}
+
+func (s *OIDCStateStore) Save(ctx context.Context, state string, value OIDCLoginState, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return s.rdb.Set(ctx, oidcStateKey(state), data, ttl).Err()
+}
+
+// Take returns and deletes the state, so each one completes a single login
+func (s *OIDCStateStore) Take(ctx context.Context, state string) (OIDCLoginState, error) {
+	data, err := s.rdb.GetDel(ctx, oidcStateKey(state)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return OIDCLoginState{}, ErrNotFound
+		}
+		return OIDCLoginState{}, err
+	}
+
+	var value OIDCLoginState
+	err = json.Unmarshal(data, &value)
+	if err != nil {
+		return OIDCLoginState{}, err
+	}
+
+	return value, nil
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// LockedUser is an account locked after too many failed logins
+type LockedUser struct {
+	ID                  int       `json:"id"`
+	FirstName           string    `json:"first_name"`
+	LastName            string    `json:"last_name"`
+	Email               string    `json:"email"`
+	FailedLoginAttempts int       `json:"failed_login_attempts"`
+	LockedUntil         time.Time `json:"locked_until"`
+}
+
+// LockoutStore counts failed logins. Per account counts live on the users row
+// so admins can see them, per IP counts are short lived and kept in Redis.
+type LockoutStore struct {
+	db  *sql.DB
+	rdb *redis.Client
+}
+
+func ipFailuresKey(ip string) string {
+	return fmt.Sprintf("login:failures:ip:%s", ip)
+}
+
+// RecordFailure counts a failed login against the account and locks it for
+// lockFor once maxAttempts is reached. The count starts over once an earlier
+// lock has run out. It returns the attempt count and whether this failure is
+// the one that locked the account.
+func (s *LockoutStore) RecordFailure(ctx context.Context, userID int, maxAttempts int, lockFor time.Duration) (int, bool, error) {
+
+	// NOW() is fixed for the transaction, so the lock was set by this statement
+	// exactly when it ends at NOW() + lockFor
+	query := `
+		UPDATE users SET
+			failed_login_attempts = CASE
+				WHEN locked_until <= NOW() THEN 1
+				ELSE failed_login_attempts + 1
+			END,
+			locked_until = CASE
+				WHEN locked_until > NOW() THEN locked_until
+				WHEN (CASE WHEN locked_until <= NOW() THEN 1 ELSE failed_login_attempts + 1 END) >= $2 THEN NOW() + make_interval(secs => $3)
+				ELSE NULL
+			END
+		WHERE id = $1
+		RETURNING failed_login_attempts, COALESCE(locked_until = NOW() + make_interval(secs => $3), false)
+	`
+
+	var (
+		attempts int
+		locked   bool
+	)
+	err := s.db.QueryRowContext(ctx, query, userID, maxAttempts, lockFor.Seconds()).Scan(&attempts, &locked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, ErrNoRows
+		}
+		return 0, false, err
+	}
+
+	return attempts, locked, nil
+}
+
+// Reset clears the failed attempts and any lock on the account
+func (s *LockoutStore) Reset(ctx context.Context, userID int) error {
+
+	query := `
+		UPDATE users SET failed_login_attempts = 0, locked_until = NULL
+		WHERE id = $1
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *LockoutStore) ListLocked(ctx context.Context) ([]LockedUser, error) {
+
+	query := `
+		SELECT id, first_name, last_name, email, failed_login_attempts, locked_until
+		FROM users
+		WHERE locked_until > NOW()
+		ORDER BY locked_until DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	users := []LockedUser{}
+
+	for rows.Next() {
+		var user LockedUser
+		err := rows.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.FailedLoginAttempts, &user.LockedUntil)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// RecordIPFailure counts a failed login from an IP within window and returns the count
+func (s *LockoutStore) RecordIPFailure(ctx context.Context, ip string, window time.Duration) (int, error) {
+	key := ipFailuresKey(ip)
+
+	pipe := s.rdb.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, window)
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(incr.Val()), nil
+}
+
+// IPFailures returns the failed logins from an IP in the current window
+func (s *LockoutStore) IPFailures(ctx context.Context, ip string) (int, error) {
+	n, err := s.rdb.Get(ctx, ipFailuresKey(ip)).Int()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return n, nil
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// OutboxMessage is an email waiting to be delivered
+type OutboxMessage struct {
+	ID        int64
+	Recipient string
+	Subject   string
+	TextBody  string
+	HTMLBody  string
+	Attempts  int
+}
+
+type OutboxStore struct {
+	db *sql.DB
+}
+
+func (s *OutboxStore) Enqueue(ctx context.Context, msg OutboxMessage) error {
+
+	query := `
+		INSERT INTO mail_outbox (recipient, subject, text_body, html_body)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err := s.db.ExecContext(ctx, query, msg.Recipient, msg.Subject, msg.TextBody, msg.HTMLBody)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Claim takes up to limit due messages that have had fewer than maxAttempts
+// tries. Claimed messages are pushed back by lease so that other instances
+// skip them while they are being sent; if this one dies they are retried once
+// the lease runs out.
+func (s *OutboxStore) Claim(ctx context.Context, limit int, maxAttempts int, lease time.Duration) ([]OutboxMessage, error) {
+
+	query := `
+		UPDATE mail_outbox SET
+			attempts = attempts + 1,
+			next_attempt_at = NOW() + make_interval(secs => $3)
+		WHERE id IN (
+			SELECT id FROM mail_outbox
+			WHERE sent_at IS NULL AND next_attempt_at <= NOW() AND attempts < $2
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, recipient, subject, text_body, html_body, attempts
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, limit, maxAttempts, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	messages := []OutboxMessage{}
+
+	for rows.Next() {
+		var msg OutboxMessage
+		err := rows.Scan(&msg.ID, &msg.Recipient, &msg.Subject, &msg.TextBody, &msg.HTMLBody, &msg.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// MarkSent records the delivery and blanks the bodies, which can hold live
+// reset, verification and sign in links
+func (s *OutboxStore) MarkSent(ctx context.Context, id int64) error {
+
+	query := `
+		UPDATE mail_outbox SET sent_at = NOW(), last_error = NULL, text_body = '', html_body = ''
+		WHERE id = $1
+	`
+
+	_, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MarkFailed records why delivery failed and when to try again
+func (s *OutboxStore) MarkFailed(ctx context.Context, id int64, reason string, retryAt time.Time) error {
+
+	query := `
+		UPDATE mail_outbox SET last_error = $2, next_attempt_at = $3
+		WHERE id = $1
+	`
+
+	_, err := s.db.ExecContext(ctx, query, id, reason, retryAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GiveUp records the last failure of a message that won't be retried and
+// blanks its bodies like MarkSent
+func (s *OutboxStore) GiveUp(ctx context.Context, id int64, reason string) error {
+
+	query := `
+		UPDATE mail_outbox SET last_error = $2, text_body = '', html_body = ''
+		WHERE id = $1
+	`
+
+	_, err := s.db.ExecContext(ctx, query, id, reason)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Purge deletes messages that were sent, or given up on after maxAttempts,
+// before the cutoff and returns how many went
+func (s *OutboxStore) Purge(ctx context.Context, maxAttempts int, before time.Time) (int64, error) {
+
+	query := `
+		DELETE FROM mail_outbox
+		WHERE sent_at < $2 OR (sent_at IS NULL AND attempts >= $1 AND created_at < $2)
+	`
+
+	result, err := s.db.ExecContext(ctx, query, maxAttempts, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// QuestionSort is the order questions are listed in
+type QuestionSort string
+
+const (
+	SortNewest       QuestionSort = "newest"
+	SortOldest       QuestionSort = "oldest"
+	SortMostAnswered QuestionSort = "answers"
+	SortMostVoted    QuestionSort = "votes"
+)
+
+// Valid reports whether the sort is one we know about
+func (s QuestionSort) Valid() bool {
+	switch s {
+	case SortNewest, SortOldest, SortMostAnswered, SortMostVoted:
+		return true
+	}
+	return false
+}
+
+// QuestionFilter narrows down and pages through the question listing
+type QuestionFilter struct {
+	Sort     QuestionSort
+	Limit    int
+	Cursor   string
+	UserID   int
+	Location string
+	From     time.Time
+	To       time.Time
+}
+
+// cursor marks the last row of a page. Key holds the value of the sort column
+// and ID breaks ties between rows sharing it.
+type cursor struct {
+	Sort QuestionSort `json:"s"`
+	Key  string       `json:"k"`
+	ID   int          `json:"i"`
+}
+
+func (c cursor) encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(value string, sort QuestionSort) (cursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	var c cursor
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.Sort != sort {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// cursorFor builds the cursor pointing after the given question
+func cursorFor(sort QuestionSort, q Question) cursor {
+	c := cursor{Sort: sort, ID: q.ID}
+
+	switch sort {
+	case SortMostAnswered:
+		c.Key = strconv.Itoa(q.AnswerCount)
+	case SortMostVoted:
+		c.Key = strconv.Itoa(q.VoteScore)
+	default:
+		c.Key = q.CreatedAt.Format(time.RFC3339Nano)
+	}
+
+	return c
+}
+
+// keyValue turns the cursor key back into the type of the sort column
+func (c cursor) keyValue() (any, error) {
+	switch c.Sort {
+	case SortMostAnswered, SortMostVoted:
+		n, err := strconv.Atoi(c.Key)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return n, nil
+	default:
+		t, err := time.Parse(time.RFC3339Nano, c.Key)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return t, nil
+	}
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// How many rows are hashed or purged per statement, so neither job holds
+// locks on a large part of the table
+const refreshTokenBatchSize = 1000
+
+// hashToken keys the refresh token with the server pepper. Refresh tokens are
+// signed JWTs with plenty of entropy, a fast keyed hash is enough to make a
+// leaked table useless without the pepper.
+func (s *AuthStore) hashToken(token string) string {
+	mac := hmac.New(sha256.New, s.pepper)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HashStoredRefreshTokens hashes refresh tokens that were stored in the clear
+// before tokens were hashed and drops the raw value. It returns how many rows
+// were converted and is safe to run on every startup.
+func (s *AuthStore) HashStoredRefreshTokens(ctx context.Context) (int, error) {
+	total := 0
+
+	for {
+		n, err := s.hashStoredBatch(ctx)
+		if err != nil {
+			return total, err
+		}
+		total += n
+
+		if n < refreshTokenBatchSize {
+			return total, nil
+		}
+	}
+}
+
+func (s *AuthStore) hashStoredBatch(ctx context.Context) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, token
+		FROM refresh_tokens
+		WHERE token IS NOT NULL
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`
+
+	rows, err := tx.QueryContext(ctx, query, refreshTokenBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	hashes := map[int64]string{}
+	for rows.Next() {
+		var (
+			id    int64
+			token string
+		)
+		err := rows.Scan(&id, &token)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		hashes[id] = s.hashToken(token)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	query = `
+		UPDATE refresh_tokens SET token_hash = $1, token = NULL
+		WHERE id = $2
+	`
+
+	for id, hash := range hashes {
+		_, err = tx.ExecContext(ctx, query, hash, id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(hashes), nil
+}
+
+// PurgeExpiredRefreshTokens deletes refresh tokens that expired before the
+// given time, then the sessions left with nothing to refresh. It returns how
+// many refresh tokens were removed.
+func (s *AuthStore) PurgeExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	var total int64
+
+	tokensQuery := `
+		DELETE FROM refresh_tokens
+		WHERE id IN (
+			SELECT id FROM refresh_tokens WHERE expires_at < $1 LIMIT $2
+		)
+	`
+
+	for {
+		n, err := s.deleteBatch(ctx, tokensQuery, before)
+		if err != nil {
+			return total, err
+		}
+		total += n
+
+		if n < refreshTokenBatchSize {
+			break
+		}
+	}
+
+	// A session expires with its newest refresh token, so its tokens are gone by now
+	sessionsQuery := `
+		DELETE FROM sessions
+		WHERE id IN (
+			SELECT id FROM sessions WHERE expires_at < $1 LIMIT $2
+		)
+	`
+
+	for {
+		n, err := s.deleteBatch(ctx, sessionsQuery, before)
+		if err != nil {
+			return total, err
+		}
+
+		if n < refreshTokenBatchSize {
+			return total, nil
+		}
+	}
+}
+
+func (s *AuthStore) deleteBatch(ctx context.Context, query string, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, query, before, refreshTokenBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,35 @@
+package store
+
+// Role is the level of access a user has on the platform
+type Role string
+
+const (
+	RoleMember    Role = "member"
+	RoleScholar   Role = "scholar"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// roleRanks orders the roles from least to most privileged
+var roleRanks = map[Role]int{
+	RoleMember:    1,
+	RoleScholar:   2,
+	RoleModerator: 3,
+	RoleAdmin:     4,
+}
+
+// Valid reports whether the role is one we know about
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// AtLeast reports whether the role grants at least the privileges of other
+func (r Role) AtLeast(other Role) bool {
+	rank, ok := roleRanks[r]
+	if !ok {
+		return false
+	}
+
+	return rank >= roleRanks[other]
+}
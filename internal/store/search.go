@@ -0,0 +1,302 @@
+package store
+
+import (
+	"context"
+	"html"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SearchResult is a question or reply matching a search. QuestionID is the top
+// level question the match belongs to, so replies can be shown in context.
+type SearchResult struct {
+	ID         int       `json:"id"`
+	QuestionID int       `json:"question_id"`
+	ParentID   int       `json:"parent_id"`
+	Type       string    `json:"type"`
+	Content    string    `json:"content"`
+	Snippet    string    `json:"snippet"`
+	Rank       float64   `json:"rank"`
+	UserID     int       `json:"user_id"`
+	Location   string    `json:"location"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Postgres marks the matches in the normalised text with private use
+// characters, stripped from it beforehand, so the marked words can be read back
+const (
+	snippetStart = "\uE000"
+	snippetStop  = "\uE001"
+)
+
+// How snippets are cut, following ts_headline's MaxFragments and MaxWords
+const (
+	snippetFragments = 2
+	snippetWords     = 25
+	// Words of context shown before the first match in a fragment
+	snippetLead = 5
+)
+
+// isWordRune matches what Normalize treats as part of a word, plus combining
+// marks such as harakat which it folds away
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r)
+}
+
+func splitWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool { return !isWordRune(r) })
+}
+
+// markedTerms returns the words a headline marked as matches. Postgres can
+// mark a hyphenated word whole, so its parts are returned instead.
+func markedTerms(headline string) map[string]bool {
+	terms := map[string]bool{}
+
+	for {
+		_, rest, ok := strings.Cut(headline, snippetStart)
+		if !ok {
+			return terms
+		}
+
+		term, after, _ := strings.Cut(rest, snippetStop)
+		for _, word := range splitWords(term) {
+			terms[word] = true
+		}
+		headline = after
+	}
+}
+
+// highlightSnippet cuts a snippet from the content as written and marks every
+// word whose normalised form Postgres matched, so matches found through
+// folding or synonyms are highlighted too. The result is safe to render as
+// HTML, any markup the author wrote is escaped.
+func (s *QuestionStore) highlightSnippet(content string, headline string) string {
+	terms := markedTerms(headline)
+
+	type word struct {
+		start, end int
+		match      bool
+	}
+
+	var words []word
+	start := -1
+	for i, r := range content + " " {
+		if i < len(content) && isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start < 0 {
+			continue
+		}
+
+		w := word{start: start, end: i}
+		for _, term := range splitWords(s.normalizer.Normalize(content[start:i])) {
+			if terms[term] {
+				w.match = true
+				break
+			}
+		}
+		words = append(words, w)
+		start = -1
+	}
+
+	if len(words) == 0 {
+		return html.EscapeString(content)
+	}
+
+	// Fragments open a few words before a match not already shown, with the
+	// start of the content as a fallback when nothing can be placed
+	type fragment struct{ first, last int }
+	var fragments []fragment
+	for i, w := range words {
+		if len(fragments) == snippetFragments {
+			break
+		}
+		if !w.match || (len(fragments) > 0 && i <= fragments[len(fragments)-1].last) {
+			continue
+		}
+
+		first := max(i-snippetLead, 0)
+		if len(fragments) > 0 {
+			first = max(first, fragments[len(fragments)-1].last+1)
+		}
+		fragments = append(fragments, fragment{first, min(first+snippetWords, len(words)) - 1})
+	}
+	if len(fragments) == 0 {
+		fragments = append(fragments, fragment{0, min(snippetWords, len(words)) - 1})
+	}
+
+	var b strings.Builder
+	for n, f := range fragments {
+		if n > 0 {
+			b.WriteString(" ... ")
+		}
+
+		// Punctuation before the first word or after the last stays
+		pos, end := words[f.first].start, words[f.last].end
+		if f.first == 0 {
+			pos = 0
+		}
+		if f.last == len(words)-1 {
+			end = len(content)
+		}
+
+		for _, w := range words[f.first : f.last+1] {
+			if !w.match {
+				continue
+			}
+			b.WriteString(html.EscapeString(content[pos:w.start]))
+			b.WriteString("<mark>")
+			b.WriteString(html.EscapeString(content[w.start:w.end]))
+			b.WriteString("</mark>")
+			pos = w.end
+		}
+		b.WriteString(html.EscapeString(content[pos:end]))
+	}
+
+	return b.String()
+}
+
+// headlineConfig picks the text search configuration used to highlight matches,
+// which has to match the language the query was written in
+func headlineConfig(query string) string {
+	for _, r := range query {
+		if unicode.Is(unicode.Arabic, r) {
+			return "arabic"
+		}
+	}
+	return "english"
+}
+
+// Search runs a full text search over questions and replies, best matches first
+func (s *QuestionStore) Search(ctx context.Context, text string, limit int, offset int) ([]SearchResult, error) {
+
+	query := `
+		WITH search AS (
+			SELECT websearch_to_tsquery('english', $1) || websearch_to_tsquery('arabic', $1) AS query
+		)
+		SELECT
+			q.id,
+			(
+				WITH RECURSIVE ancestors AS (
+					SELECT id, parent_id FROM questions WHERE id = q.id
+					UNION ALL
+					SELECT p.id, p.parent_id FROM questions p JOIN ancestors a ON p.id = a.parent_id
+				)
+				SELECT id FROM ancestors WHERE parent_id IS NULL
+			),
+			COALESCE(q.parent_id, 0),
+			q.content,
+			ts_headline($2::regconfig, translate(COALESCE(q.search_content, q.content), $5, ''), search.query, 'StartSel=' || $6 || ', StopSel=' || $7 || ', HighlightAll=true'),
+			ts_rank_cd(q.search_vector, search.query),
+			q.user_id,
+			q.location,
+			q.created_at
+		FROM questions q, search
+		WHERE q.search_vector @@ search.query
+		ORDER BY 6 DESC, q.id DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, s.normalizer.Normalize(text), headlineConfig(text), limit, offset, snippetStart+snippetStop, snippetStart, snippetStop)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	results := []SearchResult{}
+
+	for rows.Next() {
+		var result SearchResult
+		err := rows.Scan(&result.ID, &result.QuestionID, &result.ParentID, &result.Content, &result.Snippet, &result.Rank, &result.UserID, &result.Location, &result.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Snippet = s.highlightSnippet(result.Content, result.Snippet)
+
+		result.Type = "question"
+		if result.ParentID != 0 {
+			result.Type = "answer"
+		}
+
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Reindex recomputes the normalised search text of every question and reply,
+// needed after the synonym dictionary changes. It returns the number of rows updated.
+func (s *QuestionStore) Reindex(ctx context.Context) (int, error) {
+
+	const batchSize = 500
+
+	query := `
+		SELECT id, content FROM questions
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2
+	`
+
+	update := `
+		UPDATE questions SET search_content = $1
+		WHERE id = $2 AND search_content IS DISTINCT FROM $1
+	`
+
+	lastID, updated := 0, 0
+
+	for {
+		rows, err := s.db.QueryContext(ctx, query, lastID, batchSize)
+		if err != nil {
+			return updated, err
+		}
+
+		type row struct {
+			id      int
+			content string
+		}
+		batch := []row{}
+
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.content); err != nil {
+				rows.Close()
+				return updated, err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return updated, err
+		}
+
+		for _, r := range batch {
+			result, err := s.db.ExecContext(ctx, update, s.normalizer.Normalize(r.content), r.id)
+			if err != nil {
+				return updated, err
+			}
+
+			n, err := result.RowsAffected()
+			if err != nil {
+				return updated, err
+			}
+			updated += int(n)
+
+			lastID = r.id
+		}
+
+		if len(batch) < batchSize {
+			return updated, nil
+		}
+	}
+}
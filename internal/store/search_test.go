@@ -0,0 +1,80 @@
+package store
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/RakibulBh/shaheed-backend/internal/textnorm"
+)
+
+func TestHighlightSnippet(t *testing.T) {
+	s := &QuestionStore{normalizer: textnorm.New(map[string]string{"namaz": "salah", "salat": "salah"})}
+
+	mark := func(terms ...string) string {
+		var b strings.Builder
+		for _, term := range terms {
+			b.WriteString(snippetStart + term + snippetStop + " ")
+		}
+		return b.String()
+	}
+
+	long := strings.Repeat("filler ", 40)
+
+	tests := []struct {
+		name     string
+		content  string
+		headline string
+		want     string
+	}{
+		{
+			name:     "synonym",
+			content:  "How do I pray Namaz while travelling?",
+			headline: mark("salah"),
+			want:     "How do I pray <mark>Namaz</mark> while travelling?",
+		},
+		{
+			name:     "diacritics",
+			content:  "Is ṣalāh valid without wuḍūʾ?",
+			headline: mark("salah", "wudu"),
+			want:     "Is <mark>ṣalāh</mark> valid without <mark>wuḍūʾ</mark>?",
+		},
+		{
+			name:     "arabic",
+			content:  "ما حكم الصَّلاة في السفر",
+			headline: mark("الصلاه"),
+			want:     "ما حكم <mark>الصَّلاة</mark> في السفر",
+		},
+		{
+			name:     "hyphenated",
+			content:  "Praying al-Fajr late",
+			headline: mark("al-fajr"),
+			want:     "Praying <mark>al</mark>-<mark>Fajr</mark> late",
+		},
+		{
+			name:     "markup is escaped",
+			content:  `<script>alert("salat")</script>`,
+			headline: mark("salah"),
+			want:     `&lt;script&gt;alert(&#34;<mark>salat</mark>&#34;)&lt;/script&gt;`,
+		},
+		{
+			name:     "fragments",
+			content:  "salat " + long + "namaz " + long,
+			headline: mark("salah"),
+			want:     "<mark>salat</mark>" + strings.Repeat(" filler", 24) + " ... " + strings.Repeat("filler ", 5) + "<mark>namaz</mark>" + strings.Repeat(" filler", 19),
+		},
+		{
+			name:     "no match",
+			content:  long,
+			headline: "",
+			want:     strings.TrimSpace(strings.Repeat("filler ", snippetWords)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.highlightSnippet(tt.content, tt.headline); got != tt.want {
+				t.Errorf("highlightSnippet() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Device describes where a login came from
+type Device struct {
+	UserAgent string
+	IP        string
+	Label     string
+}
+
+// Session is one login on one device
+type Session struct {
+	ID         string    `json:"id"`
+	Label      string    `json:"label"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// How often a session's last used time is written while it is being used
+const sessionTouchInterval = time.Minute * 5
+
+type SessionStore struct {
+	db  *sql.DB
+	rdb *redis.Client
+}
+
+// List returns the user's active sessions, most recently used first
+func (s *SessionStore) List(ctx context.Context, userID int) ([]Session, error) {
+
+	query := `
+		SELECT id, label, user_agent, ip, created_at, last_used_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sessions := []Session{}
+
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.ID, &session.Label, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Revoke ends one of the user's sessions so it can no longer be refreshed
+func (s *SessionStore) Revoke(ctx context.Context, userID int, sessionID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+
+	result, err := tx.ExecContext(ctx, query, sessionID, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	query = `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`
+
+	_, err = tx.ExecContext(ctx, query, sessionID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Touch records that the session was just used. To spare the database it
+// writes at most once per sessionTouchInterval for each session.
+func (s *SessionStore) Touch(ctx context.Context, sessionID string, ip string) error {
+	first, err := s.rdb.SetNX(ctx, "session:touched:"+sessionID, 1, sessionTouchInterval).Result()
+	if err != nil || !first {
+		return err
+	}
+
+	query := `
+		UPDATE sessions SET last_used_at = NOW(), ip = $2
+		WHERE id = $1 AND revoked_at IS NULL
+	`
+
+	_, err = s.db.ExecContext(ctx, query, sessionID, ip)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -6,7 +6,8 @@ import (
 	"errors"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/RakibulBh/shaheed-backend/internal/textnorm"
+	"github.com/redis/go-redis/v9"
 )
 
 // Errors
@@ -16,37 +17,141 @@ var (
 	ErrConflict = errors.New("conflict")
 	ErrInternal = errors.New("internal server error")
 	ErrInvalid  = errors.New("invalid input")
+
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
 )
 
 type Storage struct {
 	Questions interface {
 		Create(ctx context.Context, userID int, content string, parentID int, location string) (*Question, error)
-		GetQuestions(ctx context.Context) ([]Question, error)
+		GetQuestions(ctx context.Context, filter QuestionFilter) ([]Question, string, error)
 		Get(ctx context.Context, id int) (*Question, error)
+		GetByID(ctx context.Context, id int) (*Question, error)
+		GetAnswers(ctx context.Context, questionID int) ([]Question, error)
 		Update(ctx context.Context, question *Question) error
 		Delete(ctx context.Context, id int) error
-		VerifyContent(ctx context.Context, content string, modelName string, apiKey string) (bool, string, error)
+		Vote(ctx context.Context, questionID int, userID int, value int) error
+		Search(ctx context.Context, text string, limit int, offset int) ([]SearchResult, error)
 		FlagQuestion(ctx context.Context, userID int, content string, parentID int, location string, reason string) error
+		Reindex(ctx context.Context) (int, error)
+	}
+	Synonyms interface {
+		List(ctx context.Context) ([]Synonym, error)
+		Upsert(ctx context.Context, term string, canonical string) error
+		Delete(ctx context.Context, term string) error
+	}
+	Flagged interface {
+		List(ctx context.Context, status FlagStatus) ([]FlaggedQuestion, error)
+		Get(ctx context.Context, id int) (*FlaggedQuestion, error)
+		Approve(ctx context.Context, id int, moderatorID int, content string, location string, note string) (*Question, error)
+		Reject(ctx context.Context, id int, moderatorID int, note string) error
 	}
 	Auth interface {
 		HashPassword(password string) (string, error)
-		Register(ctx context.Context, request RegisterRequest) error
+		Register(ctx context.Context, request RegisterRequest) (int, error)
 		VerifyPassword(password string, hash string) (bool, error)
-		GenerateJWT(userID int, expiresAt time.Time, secret string) (string, error)
-		VerifyToken(tokenString string, secret string) (*jwt.Token, error)
-		StoreRefreshToken(ctx context.Context, userID int, token string, expiresAt time.Time) error
-		RefreshToken(ctx context.Context, userID int, tokenString string, secret string, refreshExp time.Duration, accessExp time.Duration) (string, string, error)
+		GenerateJWT(userID int, sessionID string, tokenType TokenType, expiresAt time.Time, cfg TokenConfig) (string, error)
+		VerifyToken(tokenString string, tokenType TokenType, cfg TokenConfig) (*Claims, error)
+		StoreRefreshToken(ctx context.Context, userID int, sessionID string, token string, expiresAt time.Time, device Device) error
+		RefreshToken(ctx context.Context, userID int, tokenString string, ip string, cfg TokenConfig, refreshExp time.Duration, accessExp time.Duration) (string, string, error)
+		RevokeRefreshToken(ctx context.Context, userID int, token string) error
+		RevokeUserRefreshTokens(ctx context.Context, userID int) ([]string, error)
+		HashStoredRefreshTokens(ctx context.Context) (int, error)
+		PurgeExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error)
+	}
+	TwoFactor interface {
+		Get(ctx context.Context, userID int) (TwoFactor, error)
+		SetPendingSecret(ctx context.Context, userID int, secret string) error
+		Enable(ctx context.Context, userID int, step int64, recoveryCodes []string) error
+		Disable(ctx context.Context, userID int) error
+		UseStep(ctx context.Context, userID int, step int64) (bool, error)
+		UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error)
+		ListPolicies(ctx context.Context) ([]RolePolicy, error)
+		SetPolicy(ctx context.Context, role Role, requireTwoFactor bool) error
+	}
+	Identities interface {
+		FindUser(ctx context.Context, provider string, subject string) (int, error)
+		Link(ctx context.Context, userID int, provider string, subject string, email string) error
+		CreateUser(ctx context.Context, user ExternalUser) (int, error)
+	}
+	OIDCStates interface {
+		Save(ctx context.Context, state string, value OIDCLoginState, ttl time.Duration) error
+		Take(ctx context.Context, state string) (OIDCLoginState, error)
+	}
+	Tokens interface {
+		Create(ctx context.Context, userID int, scope TokenScope, ttl time.Duration) (string, error)
+		CreateBound(ctx context.Context, userID int, scope TokenScope, ttl time.Duration, binding string) (string, error)
+		Consume(ctx context.Context, scope TokenScope, token string) (int, error)
+		ConsumeBound(ctx context.Context, scope TokenScope, token string, binding string) (int, error)
+		DeleteForUser(ctx context.Context, userID int, scope TokenScope) error
+	}
+	Outbox interface {
+		Enqueue(ctx context.Context, msg OutboxMessage) error
+		Claim(ctx context.Context, limit int, maxAttempts int, lease time.Duration) ([]OutboxMessage, error)
+		MarkSent(ctx context.Context, id int64) error
+		MarkFailed(ctx context.Context, id int64, reason string, retryAt time.Time) error
+		GiveUp(ctx context.Context, id int64, reason string) error
+		Purge(ctx context.Context, maxAttempts int, before time.Time) (int64, error)
+	}
+	Lockout interface {
+		RecordFailure(ctx context.Context, userID int, maxAttempts int, lockFor time.Duration) (int, bool, error)
+		Reset(ctx context.Context, userID int) error
+		ListLocked(ctx context.Context) ([]LockedUser, error)
+		RecordIPFailure(ctx context.Context, ip string, window time.Duration) (int, error)
+		IPFailures(ctx context.Context, ip string) (int, error)
+	}
+	APIKeys interface {
+		Create(ctx context.Context, request NewAPIKey) (APIKey, string, error)
+		Authenticate(ctx context.Context, plain string) (APIKey, error)
+		List(ctx context.Context, userID int) ([]APIKey, error)
+		ListAll(ctx context.Context, prefix string) ([]APIKey, error)
+		Rename(ctx context.Context, userID int, id int64, name string) error
+		Revoke(ctx context.Context, userID int, id int64) error
+		RevokeAny(ctx context.Context, id int64) error
+		RevokeSelfIssued(ctx context.Context, userID int) error
+		Touch(ctx context.Context, id int64, ip string) error
+	}
+	Sessions interface {
+		List(ctx context.Context, userID int) ([]Session, error)
+		Revoke(ctx context.Context, userID int, sessionID string) error
+		Touch(ctx context.Context, sessionID string, ip string) error
+	}
+	Revocations interface {
+		RevokeToken(ctx context.Context, jti string, ttl time.Duration) error
+		IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+		RevokeSession(ctx context.Context, sessionID string, ttl time.Duration) error
+		IsSessionRevoked(ctx context.Context, sessionID string) (bool, error)
+		RevokeUserTokens(ctx context.Context, userID int, at time.Time, ttl time.Duration) error
+		UserTokensRevokedAt(ctx context.Context, userID int) (time.Time, error)
 	}
 	User interface {
 		GetUserByID(ctx context.Context, id int) (User, error)
 		GetUserByEmail(ctx context.Context, email string) (UserData, error)
+		GetUserDataByID(ctx context.Context, id int) (UserData, error)
+		UpdateRole(ctx context.Context, id int, role Role) error
+		MarkEmailVerified(ctx context.Context, id int) error
+		UpdatePassword(ctx context.Context, id int, passwordHash string) error
 	}
 }
 
-func NewStorage(db *sql.DB) Storage {
+func NewStorage(db *sql.DB, rdb *redis.Client, normalizer *textnorm.Normalizer, tokenPepper string) Storage {
+	questions := &QuestionStore{db: db, normalizer: normalizer}
+
 	return Storage{
-		Questions: &QuestionStore{db: db},
-		Auth:      &AuthStore{db: db},
-		User:      &UserStore{db: db},
+		Questions:   questions,
+		Synonyms:    &SynonymStore{db: db},
+		Flagged:     &FlaggedStore{db: db, questions: questions},
+		Auth:        &AuthStore{db: db, pepper: []byte(tokenPepper)},
+		User:        &UserStore{db: db},
+		TwoFactor:   &TwoFactorStore{db: db},
+		Identities:  &IdentityStore{db: db},
+		OIDCStates:  &OIDCStateStore{rdb: rdb},
+		Tokens:      &TokenStore{db: db},
+		Lockout:     &LockoutStore{db: db, rdb: rdb},
+		Outbox:      &OutboxStore{db: db},
+		Sessions:    &SessionStore{db: db, rdb: rdb},
+		APIKeys:     &APIKeyStore{db: db, rdb: rdb},
+		Revocations: &RevocationStore{rdb: rdb},
 	}
 }
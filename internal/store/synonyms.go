@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type SynonymStore struct {
+	db *sql.DB
+}
+
+// Synonym maps one spelling of a term onto its canonical form for search
+type Synonym struct {
+	Term      string    `json:"term"`
+	Canonical string    `json:"canonical"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s *SynonymStore) List(ctx context.Context) ([]Synonym, error) {
+
+	query := `
+		SELECT term, canonical, created_at
+		FROM search_synonyms
+		ORDER BY canonical, term
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	synonyms := []Synonym{}
+
+	for rows.Next() {
+		var synonym Synonym
+		err := rows.Scan(&synonym.Term, &synonym.Canonical, &synonym.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		synonyms = append(synonyms, synonym)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return synonyms, nil
+}
+
+func (s *SynonymStore) Upsert(ctx context.Context, term string, canonical string) error {
+
+	query := `
+		INSERT INTO search_synonyms (term, canonical)
+		VALUES ($1, $2)
+		ON CONFLICT (term) DO UPDATE SET canonical = EXCLUDED.canonical
+	`
+
+	_, err := s.db.ExecContext(ctx, query, term, canonical)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *SynonymStore) Delete(ctx context.Context, term string) error {
+
+	query := `
+		DELETE FROM search_synonyms WHERE term = $1
+	`
+
+	result, err := s.db.ExecContext(ctx, query, term)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// TokenScope is what a user token can be used for
+type TokenScope string
+
+const (
+	ScopeUnlock            TokenScope = "unlock"
+	ScopeEmailVerification TokenScope = "email_verification"
+	ScopePasswordReset     TokenScope = "password_reset"
+	ScopeMagicLink         TokenScope = "magic_link"
+)
+
+var ErrInvalidUserToken = errors.New("invalid or expired token")
+
+// TokenStore manages the single use tokens we email to users. Only a hash of
+// each token is stored so a database leak cannot be used to redeem them.
+type TokenStore struct {
+	db *sql.DB
+}
+
+func hashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create issues a new token for the user and returns its plain text form
+func (s *TokenStore) Create(ctx context.Context, userID int, scope TokenScope, ttl time.Duration) (string, error) {
+	return s.CreateBound(ctx, userID, scope, ttl, "")
+}
+
+// CreateBound issues a token that can only be redeemed together with binding,
+// a secret kept by the device that asked for it. An empty binding leaves the
+// token unbound.
+func (s *TokenStore) CreateBound(ctx context.Context, userID int, scope TokenScope, ttl time.Duration, binding string) (string, error) {
+
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	var bindingHash sql.NullString
+	if binding != "" {
+		bindingHash = sql.NullString{String: hashUserToken(binding), Valid: true}
+	}
+
+	query := `
+		INSERT INTO user_tokens (user_id, scope, token_hash, expires_at, binding_hash)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err = s.db.ExecContext(ctx, query, userID, scope, hashUserToken(token), time.Now().Add(ttl), bindingHash)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Consume marks the token as used and returns the user it belongs to
+func (s *TokenStore) Consume(ctx context.Context, scope TokenScope, token string) (int, error) {
+	return s.ConsumeBound(ctx, scope, token, "")
+}
+
+// ConsumeBound is Consume for tokens that may be bound to a device. A wrong
+// binding leaves the token unused.
+func (s *TokenStore) ConsumeBound(ctx context.Context, scope TokenScope, token string, binding string) (int, error) {
+
+	query := `
+		UPDATE user_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND scope = $2 AND used_at IS NULL AND expires_at > NOW()
+			AND (binding_hash IS NULL OR binding_hash = $3)
+		RETURNING user_id
+	`
+
+	var userID int
+	err := s.db.QueryRowContext(ctx, query, hashUserToken(token), scope, hashUserToken(binding)).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidUserToken
+		}
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// randomToken returns 256 random bits, URL safe
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewDeviceBinding returns a secret for a device to hold and present with a bound token
+func NewDeviceBinding() (string, error) {
+	return randomToken()
+}
+
+// DeleteForUser removes the user's outstanding tokens for a scope, so only the
+// most recently sent one works
+func (s *TokenStore) DeleteForUser(ctx context.Context, userID int, scope TokenScope) error {
+
+	query := `
+		DELETE FROM user_tokens
+		WHERE user_id = $1 AND scope = $2 AND used_at IS NULL
+	`
+
+	_, err := s.db.ExecContext(ctx, query, userID, scope)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
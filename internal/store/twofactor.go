@@ -0,0 +1,255 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// TwoFactor is a user's TOTP state. A secret without EnabledAt is an
+// enrolment that hasn't been confirmed yet.
+type TwoFactor struct {
+	Secret    string
+	EnabledAt *time.Time
+	LastStep  *int64
+}
+
+func (t TwoFactor) Enabled() bool {
+	return t.EnabledAt != nil
+}
+
+// RolePolicy is the security policy for a role
+type RolePolicy struct {
+	Role             Role      `json:"role"`
+	RequireTwoFactor bool      `json:"require_two_factor"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+type TwoFactorStore struct {
+	db *sql.DB
+}
+
+// hashRecoveryCode ignores case and separators, so codes can be typed the way they look
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *TwoFactorStore) Get(ctx context.Context, userID int) (TwoFactor, error) {
+
+	query := `
+		SELECT COALESCE(totp_secret, ''), totp_enabled_at, totp_last_step
+		FROM users
+		WHERE id = $1
+	`
+
+	var tf TwoFactor
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&tf.Secret, &tf.EnabledAt, &tf.LastStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TwoFactor{}, ErrNoRows
+		}
+		return TwoFactor{}, err
+	}
+
+	return tf, nil
+}
+
+// SetPendingSecret starts enrolment, replacing any earlier unconfirmed secret
+func (s *TwoFactorStore) SetPendingSecret(ctx context.Context, userID int, secret string) error {
+
+	query := `
+		UPDATE users SET totp_secret = $2, totp_last_step = NULL
+		WHERE id = $1 AND totp_enabled_at IS NULL
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// Enable confirms enrolment and stores a fresh set of recovery codes
+func (s *TwoFactorStore) Enable(ctx context.Context, userID int, step int64, recoveryCodes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $2
+		WHERE id = $1 AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL
+	`
+
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrConflict
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, codes []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hashRecoveryCode(code))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Disable turns two-factor off and throws away the secret and recovery codes
+func (s *TwoFactorStore) Disable(ctx context.Context, userID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL
+		WHERE id = $1
+	`
+
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, userID, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseStep records that a code for step was accepted. It returns false when
+// that step or a later one was already used, meaning the code is a replay.
+func (s *TwoFactorStore) UseStep(ctx context.Context, userID int, step int64) (bool, error) {
+
+	query := `
+		UPDATE users SET totp_last_step = $2
+		WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// UseRecoveryCode spends one of the user's recovery codes
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error) {
+
+	query := `
+		UPDATE recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+func (s *TwoFactorStore) ListPolicies(ctx context.Context) ([]RolePolicy, error) {
+
+	query := `
+		SELECT role, require_two_factor, updated_at
+		FROM role_policies
+		ORDER BY role
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	policies := []RolePolicy{}
+
+	for rows.Next() {
+		var policy RolePolicy
+		err := rows.Scan(&policy.Role, &policy.RequireTwoFactor, &policy.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+func (s *TwoFactorStore) SetPolicy(ctx context.Context, role Role, requireTwoFactor bool) error {
+
+	query := `
+		INSERT INTO role_policies (role, require_two_factor)
+		VALUES ($1, $2)
+		ON CONFLICT (role) DO UPDATE SET require_two_factor = EXCLUDED.require_two_factor, updated_at = NOW()
+	`
+
+	_, err := s.db.ExecContext(ctx, query, role, requireTwoFactor)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 type UserStore struct {
@@ -11,30 +12,40 @@ type UserStore struct {
 }
 
 type UserData struct {
-	ID           int
-	FirstName    string
-	LastName     string
-	Email        string
-	PasswordHash string
+	ID                  int
+	FirstName           string
+	LastName            string
+	Email               string
+	Role                Role
+	Locale              string
+	PasswordHash        string
+	EmailVerifiedAt     *time.Time
+	FailedLoginAttempts int
+	LockedUntil         *time.Time
+	TwoFactorEnabled    bool
 }
 
 type User struct {
-	ID        int
-	FirstName string
-	LastName  string
-	Email     string
+	ID               int
+	FirstName        string
+	LastName         string
+	Email            string
+	Role             Role
+	Locale           string
+	EmailVerifiedAt  *time.Time
+	TwoFactorEnabled bool
 }
 
 func (s *UserStore) GetUserByID(ctx context.Context, id int) (User, error) {
 
 	query := `
-	SELECT id, first_name, last_name, email
+	SELECT id, first_name, last_name, email, role, locale, email_verified_at, totp_enabled_at IS NOT NULL
 	FROM users
 	WHERE id = $1
 	`
 
 	var fetchedUser User
-	err := s.db.QueryRowContext(ctx, query, id).Scan(&fetchedUser.ID, &fetchedUser.FirstName, &fetchedUser.LastName, &fetchedUser.Email)
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&fetchedUser.ID, &fetchedUser.FirstName, &fetchedUser.LastName, &fetchedUser.Email, &fetchedUser.Role, &fetchedUser.Locale, &fetchedUser.EmailVerifiedAt, &fetchedUser.TwoFactorEnabled)
 
 	if err != nil {
 		switch {
@@ -49,15 +60,24 @@ func (s *UserStore) GetUserByID(ctx context.Context, id int) (User, error) {
 }
 
 func (s *UserStore) GetUserByEmail(ctx context.Context, email string) (UserData, error) {
+	return s.getUserData(ctx, "email", email)
+}
+
+func (s *UserStore) GetUserDataByID(ctx context.Context, id int) (UserData, error) {
+	return s.getUserData(ctx, "id", id)
+}
+
+// getUserData fetches a user, including their credentials, by a unique column
+func (s *UserStore) getUserData(ctx context.Context, column string, value any) (UserData, error) {
 
 	query := `
-	SELECT id, first_name, last_name, email, password_hash
+	SELECT id, first_name, last_name, email, role, locale, COALESCE(password_hash, ''), email_verified_at, failed_login_attempts, locked_until, totp_enabled_at IS NOT NULL
 	FROM users
-	WHERE email = $1
+	WHERE ` + column + ` = $1
 	`
 
 	var fecthedUser UserData
-	err := s.db.QueryRowContext(ctx, query, email).Scan(&fecthedUser.ID, &fecthedUser.FirstName, &fecthedUser.LastName, &fecthedUser.Email, &fecthedUser.PasswordHash)
+	err := s.db.QueryRowContext(ctx, query, value).Scan(&fecthedUser.ID, &fecthedUser.FirstName, &fecthedUser.LastName, &fecthedUser.Email, &fecthedUser.Role, &fecthedUser.Locale, &fecthedUser.PasswordHash, &fecthedUser.EmailVerifiedAt, &fecthedUser.FailedLoginAttempts, &fecthedUser.LockedUntil, &fecthedUser.TwoFactorEnabled)
 
 	if err != nil {
 		switch {
@@ -70,3 +90,87 @@ func (s *UserStore) GetUserByEmail(ctx context.Context, email string) (UserData,
 
 	return fecthedUser, nil
 }
+
+// IsLocked reports whether the account is locked out after failed logins
+func (u UserData) IsLocked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
+}
+
+// EmailVerified reports whether the user has confirmed their email address
+func (u User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+func (s *UserStore) UpdateRole(ctx context.Context, id int, role Role) error {
+
+	query := `
+	UPDATE users SET role = $1
+	WHERE id = $2
+	`
+
+	result, err := s.db.ExecContext(ctx, query, role, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRows
+	}
+
+	return nil
+}
+
+// MarkEmailVerified records that the user has confirmed their email address.
+// Verifying twice keeps the original time.
+func (s *UserStore) MarkEmailVerified(ctx context.Context, id int) error {
+
+	query := `
+	UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW())
+	WHERE id = $1
+	`
+
+	result, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *UserStore) UpdatePassword(ctx context.Context, id int, passwordHash string) error {
+
+	query := `
+	UPDATE users SET password_hash = $1
+	WHERE id = $2
+	`
+
+	result, err := s.db.ExecContext(ctx, query, passwordHash, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package textnorm
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// letterMap folds Arabic letters that are written interchangeably onto one form
+var letterMap = map[rune]rune{
+	'ٱ': 'ا', // alef wasla
+	'ى': 'ي', // alef maqsura
+	'ة': 'ه', // ta marbuta
+}
+
+// dropped are characters that carry no meaning for search
+var dropped = map[rune]bool{
+	'ـ':  true, // tatweel
+	'ء':  true, // standalone hamza
+	'\'': true,
+	'’':  true,
+	'‘':  true,
+	'`':  true,
+	'ʼ':  true,
+	'ʾ':  true,
+	'ʿ':  true,
+}
+
+// Fold applies the character level normalisation: lower case, no Latin or
+// Arabic diacritics, and hamza and alef variants reduced to their bare letter.
+// Decomposing first turns أ إ آ ؤ ئ into a base letter plus a combining hamza
+// or madda, which is then dropped along with the harakat.
+func Fold(text string) string {
+	var b strings.Builder
+	b.Grow(len(text))
+
+	for _, r := range norm.NFD.String(text) {
+		if unicode.Is(unicode.Mn, r) || dropped[r] {
+			continue
+		}
+		if mapped, ok := letterMap[r]; ok {
+			r = mapped
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+
+	return norm.NFC.String(b.String())
+}
+
+// Normalizer folds text and replaces every known spelling of a term with its
+// canonical form, so that salat, namaz and salah all index and search the same.
+// The dictionary can be swapped at runtime.
+type Normalizer struct {
+	mu       sync.RWMutex
+	synonyms map[string]string
+}
+
+func New(synonyms map[string]string) *Normalizer {
+	n := &Normalizer{}
+	n.SetSynonyms(synonyms)
+	return n
+}
+
+// SetSynonyms replaces the dictionary, mapping each variant to its canonical term
+func (n *Normalizer) SetSynonyms(synonyms map[string]string) {
+	folded := make(map[string]string, len(synonyms))
+	for variant, canonical := range synonyms {
+		folded[Fold(variant)] = Fold(canonical)
+	}
+
+	n.mu.Lock()
+	n.synonyms = folded
+	n.mu.Unlock()
+}
+
+// Normalize folds the text and swaps in canonical terms. Anything between
+// words is kept as is so search syntax such as quotes and minus signs survives.
+func (n *Normalizer) Normalize(text string) string {
+	folded := Fold(text)
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	var (
+		b    strings.Builder
+		word strings.Builder
+	)
+	b.Grow(len(folded))
+
+	flush := func() {
+		if word.Len() == 0 {
+			return
+		}
+		b.WriteString(n.lookup(word.String()))
+		word.Reset()
+	}
+
+	for _, r := range folded {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			word.WriteRune(r)
+			continue
+		}
+		flush()
+		b.WriteRune(r)
+	}
+	flush()
+
+	return b.String()
+}
+
+// lookup returns the canonical form of a folded word. Arabic words are also
+// tried without the definite article so الصلاه matches an entry for صلاه.
+func (n *Normalizer) lookup(word string) string {
+	if canonical, ok := n.synonyms[word]; ok {
+		return canonical
+	}
+
+	if stem, ok := strings.CutPrefix(word, "ال"); ok {
+		if canonical, ok := n.synonyms[stem]; ok {
+			return canonical
+		}
+	}
+
+	return word
+}
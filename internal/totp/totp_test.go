@@ -0,0 +1,135 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// The RFC 6238 appendix B secret, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC lists 8 digit SHA-1 codes, ours are their last 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", tt.unix, err)
+		}
+
+		want := tt.code[len(tt.code)-Digits:]
+		if code != want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, code, want)
+		}
+	}
+}
+
+func TestCodeLowercaseSecret(t *testing.T) {
+	upper, err := Code(rfcSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lower, err := Code(strings.ToLower(rfcSecret), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if upper != lower {
+		t.Errorf("lowercase secret gave %s, want %s", lower, upper)
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"two steps behind", -2, false},
+		{"one step behind", -1, true},
+		{"current step", 0, true},
+		{"one step ahead", 1, true},
+		{"two steps ahead", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, current+tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			step, ok := Validate(rfcSecret, code, now)
+			if ok != tt.ok {
+				t.Fatalf("Validate ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && step != current+tt.offset {
+				t.Errorf("Validate step = %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	for _, code := range []string{"", "28708", "2870820", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate(%q) accepted", code)
+		}
+	}
+
+	// Authenticator apps show codes as "287 082"
+	if _, ok := Validate(rfcSecret, "287 082", now); !ok {
+		t.Error("Validate rejected a code with a space")
+	}
+}
+
+// Replays are refused by TwoFactorStore.UseStep, which only accepts a step
+// later than the last one used. That only works if Validate reports the step
+// the code was made for rather than the current one.
+func TestValidateStep(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	code, err := Code(rfcSecret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := Validate(rfcSecret, code, now)
+	if !ok || step != Step(now) {
+		t.Fatalf("Validate = %d, %v, want %d, true", step, ok, Step(now))
+	}
+
+	// A step later the code is still inside the window and maps to the same step
+	step, ok = Validate(rfcSecret, code, now.Add(Period))
+	if !ok || step != Step(now) {
+		t.Errorf("Validate a step later = %d, %v, want %d, true", step, ok, Step(now))
+	}
+
+	// The previous step's code maps to that earlier step
+	previous, err := Code(rfcSecret, Step(now)-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step, ok = Validate(rfcSecret, previous, now)
+	if !ok || step != Step(now)-1 {
+		t.Errorf("Validate previous code = %d, %v, want %d, true", step, ok, Step(now)-1)
+	}
+}